@@ -0,0 +1,33 @@
+package main
+
+import (
+	conf "blanktiger/hm/configuration"
+	"blanktiger/hm/lib"
+	"errors"
+)
+
+// moves existing configs into the source directory, every path is tried even
+// if some of them fail
+func adoptMain(c *conf.Configuration) error {
+	lock, err := lib.ReadOrCreateLockfile(c.LockfilePath)
+	if err != nil {
+		return err
+	}
+
+	errs := []error{}
+	for _, path := range c.Args {
+		cfg, err := lib.Adopt(c, lock, path)
+		if err != nil {
+			c.Logger.Error("couldn't adopt", "path", path, "err", err)
+			errs = append(errs, err)
+			continue
+		}
+		c.Logger.Info("adopted", "cfgName", cfg.Name, "from", cfg.From, "to", cfg.To)
+	}
+
+	err = lock.Save(c.LockfilePath, c.DefaultIndent)
+	if err != nil {
+		return err
+	}
+	return errors.Join(errs...)
+}
@@ -3,9 +3,45 @@ package main
 import (
 	conf "blanktiger/hm/configuration"
 	"blanktiger/hm/lib"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"text/tabwriter"
 )
 
-func cliMain(c *conf.Configuration) error {
+// runs apply (or install, upgrade...), failures of single configs don't stop
+// the run, but they are reported through lib.PartialFailureError at the end
+func cliMain(ctx context.Context, c *conf.Configuration) error {
+	report := lib.NewReport(c.Command)
+	report.RunId = lib.CmdLog.Id
+	err := apply(ctx, c, report)
+	if err != nil {
+		report.Error = err.Error()
+	}
+
+	if c.Output == conf.OutputJson {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", c.DefaultIndent)
+		encodeErr := encoder.Encode(report)
+		if encodeErr != nil {
+			c.Logger.Error("couldn't print the report", "err", encodeErr)
+		}
+	} else {
+		printFailures(os.Stdout, report)
+	}
+
+	if err != nil {
+		return err
+	}
+	return report.Err()
+}
+
+func apply(ctx context.Context, c *conf.Configuration, report *lib.Report) error {
 	lockBefore, err := lib.ReadOrCreateLockfile(c.LockfilePath)
 	if err != nil {
 		c.Logger.Info("encountered an error while trying to read an existing lockfile (probably doesnt exist), creating a new one instead", "err", err)
@@ -29,11 +65,33 @@ func cliMain(c *conf.Configuration) error {
 
 	lib.CopyInstallInfo(lockBefore, lockAfter)
 
+	// NOTE: the lockfile is saved even if a step failed, so that it
+	// describes what was installed before the failure
+	err = deploy(ctx, c, lockBefore, lockAfter, report)
+	saveLockfiles(c, lockBefore, lockAfter, report)
+	report.SourceCommit = lockAfter.SourceCommit
+	if interrupted(ctx, err) {
+		return fmt.Errorf("interrupted, the lockfile was saved with what was completed: %w", err)
+	}
+	return err
+}
+
+// installs, deploys and uninstalls whatever the flags ask for, lockAfter is
+// updated as each step finishes, so after an interruption it describes exactly
+// what was done
+func deploy(ctx context.Context, c *conf.Configuration, lockBefore, lockAfter *lib.Lockfile, report *lib.Report) error {
+	release, err := lib.AcquireRoot(ctx, c, lockAfter)
+	if err != nil {
+		lib.Logger.Error("couldn't get the credentials needed to install or uninstall packages", "err", err)
+		return err
+	}
+	defer release()
+
 	globalDepsChanged := lib.DidGlobalDependenciesChange(&lockBefore.GlobalDependencies, &lockAfter.GlobalDependencies)
 	globalDepsInstalled := lib.WereGlobalDependenciesInstalled(&lockAfter.GlobalDependencies)
 	if c.Install || c.OnlyInstall || c.Upgrade {
 		if globalDepsChanged || !globalDepsInstalled || c.Upgrade {
-			err = lib.InstallGlobalDependencies(&lockAfter.GlobalDependencies)
+			err = lib.InstallGlobalDependencies(ctx, lockAfter, report)
 			if err != nil {
 				lib.Logger.Error("something went wrong while trying to install global dependencies", "err", err)
 				return err
@@ -43,36 +101,128 @@ func cliMain(c *conf.Configuration) error {
 		}
 	}
 
-	if !c.OnlyUninstall && !c.OnlyInstall {
-		toSymlink := lockAfter.Configs
-
-		if c.CopyMode {
-			err = lib.Copy(c, toSymlink)
-		} else {
-			err = lib.Symlink(c, toSymlink)
+	if c.Upgrade {
+		err = lib.UpgradeGlobalDependencies(ctx, lockAfter, report)
+		if err != nil {
+			lib.Logger.Error("something went wrong while trying to upgrade global dependencies", "err", err)
+			return err
 		}
+	}
+
+	if !c.OnlyUninstall && !c.OnlyInstall {
+		err = lib.Deploy(ctx, c, lockBefore, lockAfter, report)
 		if err != nil {
 			c.Logger.Error("encountered an error while copying/symlinking", "error", err)
 			return err
 		}
+		// NOTE: units of hidden configs are disabled before their unit
+		// files are removed
+		lib.DisableHiddenUnits(ctx, lockAfter, report)
 
 		toRemove := lockAfter.HiddenConfigs
-		err = lib.Remove(c, toRemove)
+		err = lib.Remove(ctx, c, toRemove, report)
+		if err != nil {
+			// NOTE: it's in the report, installation can still go on
+			c.Logger.Error("encountered an error while removing hidden configs", "error", err)
+		}
 	} else {
 		lib.Logger.Info("skipping copying/symlinking the config, because --only-install or --only-uninstall was passed")
 	}
 
+	installed := []string{}
 	if (c.Install || c.OnlyInstall || c.Upgrade) && !c.OnlyUninstall {
-		infoForUpdate := lib.Install(lockAfter)
+		configs := lockAfter.Configs
+		if c.Failed {
+			configs = lockBefore.FailedConfigs(configs, lib.ActionInstall)
+			lib.Logger.Info("installing only the configs that failed during the last run", "count", len(configs))
+		}
+		infoForUpdate := lib.Install(ctx, lockAfter, configs, report)
+		lockAfter.UpdateInstallInfo(infoForUpdate)
+		installed = slices.Collect(maps.Keys(infoForUpdate))
+	}
+
+	// NOTE: packages that failed to upgrade are retried with an upgrade,
+	// because they are installed already
+	if c.Upgrade || c.Failed {
+		configs := lockAfter.Configs
+		if c.Failed {
+			configs = lockBefore.FailedConfigs(configs, lib.ActionUpgrade)
+			lib.Logger.Info("upgrading only the configs that failed to upgrade during the last run", "count", len(configs))
+		}
+		infoForUpdate := lib.Upgrade(ctx, lockAfter, configs, installed, report)
 		lockAfter.UpdateInstallInfo(infoForUpdate)
 	}
 
+	if c.Install || c.OnlyInstall || c.Upgrade {
+		lib.RunInstallHooks(ctx, c.SourceCfgDir, lockBefore, lockAfter, report)
+	}
+
+	// NOTE: units are enabled once their files are deployed and packages
+	// (which often ship the unit files) are installed
+	if !c.OnlyUninstall {
+		lib.ApplyUnits(ctx, lockBefore, lockAfter, report)
+	}
+
 	if (c.Uninstall || c.OnlyUninstall) && !c.OnlyInstall {
-		infoForUpdate := lib.Uninstall(lockAfter)
+		err = lib.RunPreUninstallHook(ctx, c.SourceCfgDir, lockBefore, lockAfter, report)
+		if err != nil {
+			lib.Logger.Error("not uninstalling anything", "err", err)
+			return err
+		}
+		infoForUpdate := lib.Uninstall(ctx, lockAfter, report)
 		lockAfter.UpdateInstallInfo(infoForUpdate)
+		lib.UninstallRemovedGlobalDependencies(ctx, lockBefore, lockAfter, report)
+	}
+
+	return ctx.Err()
+}
+
+// reads the lockfile saved by the last run, an empty one if there's none
+func readLastLockfile(c *conf.Configuration) (*lib.Lockfile, error) {
+	lock, err := lib.ReadLockfile(c.LockfilePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		return &lib.EmptyLockfile, nil
 	}
+	return lock, nil
+}
 
-	err = lockAfter.Save(c.LockfilePath, c.DefaultIndent)
+// reads the lockfile saved by the last run and creates one describing the
+// source directory as it is now, with the install info of the last run
+func currentLockfiles(c *conf.Configuration) (lockBefore, lock *lib.Lockfile, err error) {
+	lockBefore, err = readLastLockfile(c)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lock, err = lib.CreateLockBasedOnConfigs(c)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	globalDependencies, err := lib.ParseGlobalDependencies(c.SourceCfgDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	lock.GlobalDependencies = globalDependencies
+
+	lib.CopyInstallInfo(lockBefore, lock)
+	return lockBefore, lock, nil
+}
+
+func saveLockfiles(c *conf.Configuration, lockBefore, lockAfter *lib.Lockfile, report *lib.Report) {
+	lockAfter.UpdateDependencyOwners()
+	lockAfter.SourceCommit = lib.SourceCommit(c.SourceDir)
+	lockAfter.RunId = report.RunId
+	// NOTE: runs that don't install anything keep the failures of the last
+	// one that did, so that `hm install --failed` still works after them
+	lockAfter.Failures = lockBefore.Failures
+	if c.Install || c.OnlyInstall || c.Upgrade {
+		lockAfter.Failures = report.Failures()
+	}
+	err := lockAfter.Save(c.LockfilePath, c.DefaultIndent)
 	if err != nil {
 		lib.Logger.Error("something went wrong while trying to save the lockfile", "err", err)
 	}
@@ -82,6 +232,27 @@ func cliMain(c *conf.Configuration) error {
 	if err != nil {
 		lib.Logger.Error("something went wrong while trying to save the lockfile diff", "err", err)
 	}
+}
+
+// failures are printed once more at the end of the run, so that they don't get
+// lost in the output of the package managers
+func printFailures(w io.Writer, report *lib.Report) {
+	if report.Failed == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "\n%d action(s) failed:\n", report.Failed)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, entry := range report.Entries {
+		if entry.Error != "" {
+			fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\n", entry.Owner, entry.Action, entry.Subject, entry.Error)
+		}
+	}
+	tw.Flush()
+	fmt.Fprintln(w, "run `hm logs <config>` to see the output of the commands, `hm install --failed` to retry the installations")
+}
 
-	return nil
+// reports whether err was caused by ctx being done (e.g. C-c)
+func interrupted(ctx context.Context, err error) bool {
+	return ctx.Err() != nil && errors.Is(err, ctx.Err())
 }
@@ -0,0 +1,38 @@
+package main
+
+import (
+	conf "blanktiger/hm/configuration"
+	"blanktiger/hm/instructions"
+	"blanktiger/hm/lib"
+	"io"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplySavesTheLockfileWhenAStepFails(t *testing.T) {
+	lib.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	instructions.Logger = lib.Logger
+	c := &conf.Configuration{
+		Install:   true,
+		SourceDir: t.TempDir(),
+		TargetDir: t.TempDir(),
+		Logger:    lib.Logger,
+	}
+	c.SourceCfgDir = c.SourceDir + "/config"
+	c.LockfilePath = c.TargetDir + "/hmlock.json"
+	c.LockfileDiffPath = c.TargetDir + "/hmlock_diff.json"
+	assert.NoError(t, os.MkdirAll(c.SourceCfgDir, 0o755))
+	assert.NoError(t, os.WriteFile(c.SourceCfgDir+"/DEPENDENCIES", []byte("bash:true\nbash:false\n"), 0o644))
+
+	err := apply(t.Context(), c, lib.NewReport("apply"))
+
+	assert.Error(t, err)
+	lock, readErr := lib.ReadLockfile(c.LockfilePath)
+	assert.NoError(t, readErr)
+	assert.Len(t, lock.GlobalDependencies, 2)
+	assert.True(t, lock.GlobalDependencies[0].InstallInfo.IsInstalled, "installed before the failure")
+	assert.False(t, lock.GlobalDependencies[1].InstallInfo.IsInstalled)
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	conf "blanktiger/hm/configuration"
+	"fmt"
+	"os"
+	"text/tabwriter"
+)
+
+// prints the effective value of every setting and where it came from
+func configMain(c *conf.Configuration) error {
+	fmt.Printf("settings file: %s\n", c.SettingsPath)
+	fmt.Printf("profile: %s\n\n", c.ProfilePath)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SETTING\tVALUE\tSOURCE")
+	for _, setting := range c.Settings {
+		source := string(setting.Source)
+		if setting.Source == conf.SourceEnv {
+			source += " (" + conf.EnvVarName(setting.Name) + ")"
+		}
+		fmt.Fprintf(w, "%s\t%q\t%s\n", setting.Name, setting.Value, source)
+	}
+	return w.Flush()
+}
@@ -1,10 +1,14 @@
 package configuration
 
 import (
+	"errors"
 	"flag"
+	"io"
 	"log/slog"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 	// "reflect"
 )
 
@@ -20,22 +24,112 @@ type Configuration struct {
 
 	Debug bool `txt:"exclude"`
 	Tui   bool `txt:"exclude"`
-
+	// generate INSTALL for adopted configs
+	DetectInstall bool `txt:"exclude"`
+	// apply even if the source repository is dirty or diverged
+	Force bool `txt:"exclude"`
+	// install only the configs that failed during the last run
+	Failed bool `txt:"exclude"`
+	// uninstall the packages listed by `hm orphans`
+	RemoveOrphans bool `txt:"exclude"`
+
+	// subcommand, `apply` if none was given
+	Command string
+	// arguments left after the flags, e.g. paths for `hm adopt`
+	Args []string
+	// git url or path `hm init` starts the source directory from
+	InitFrom string
+	// format of reports, OutputText or OutputJson
+	Output string
+	// tool used to run commands as root, empty means detect it
+	Escalate string
+	// number of configs installed or upgraded at the same time
+	Jobs int
+	// commands running longer than this are interrupted, 0 means no limit
+	Timeout   time.Duration
 	PkgsTxt   string
 	SourceDir string
 	TargetDir string
+	// git remote the source directory is cloned from, empty if --sourcedir
+	// is a plain directory
+	SourceRepo string
 
 	Pkgs             []string
 	SourceCfgDir     string
 	LockfilePath     string
 	LockfileDiffPath string
-	HomeDir          string
-	Logger           *slog.Logger
-	DefaultIndent    string
+	SettingsPath     string
+	// description of the machine overriding the detected facts
+	ProfilePath string
+	// output of the commands run by hm, one directory per run
+	LogsDir string
+	// effective value of every flag and where it came from
+	Settings      []Setting
+	HomeDir       string
+	Logger        *slog.Logger
+	DefaultIndent string
+}
+
+// SettingsPath is where hm keeps its own settings,
+// `$XDG_CONFIG_HOME/hm/settings.toml` or `~/.config/hm/settings.toml`
+func SettingsPath(homeDir string) string {
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		configHome = homeDir + "/.config"
+	}
+	return configHome + "/hm/settings.toml"
+}
+
+// ProfilePath is where the description of the machine is kept, next to the
+// settings, because it's not shared between machines like the source directory
+func ProfilePath(homeDir string) string {
+	return filepath.Dir(SettingsPath(homeDir)) + "/profile.toml"
+}
+
+// LogsDir is where output of the commands run by hm is kept,
+// `$XDG_STATE_HOME/hm/logs` or `~/.local/state/hm/logs`
+func LogsDir(homeDir string) string {
+	stateHome := os.Getenv("XDG_STATE_HOME")
+	if stateHome == "" {
+		stateHome = homeDir + "/.local/state"
+	}
+	return stateHome + "/hm/logs"
+}
+
+// IsGitUrl tells remote repositories apart from local directories
+func IsGitUrl(path string) bool {
+	return strings.Contains(path, "://") || strings.HasPrefix(path, "git@") || strings.HasSuffix(path, ".git")
+}
+
+// ManagedSourceDir is where a source repository gets cloned,
+// `$XDG_DATA_HOME/hm/sources/<repo>`, the name is derived from the whole url,
+// so that repositories with the same name don't clash
+func ManagedSourceDir(homeDir, repo string) string {
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		dataHome = homeDir + "/.local/share"
+	}
+
+	_, name, found := strings.Cut(repo, "://")
+	if !found {
+		name = strings.TrimPrefix(repo, "git@")
+	}
+	name = strings.TrimSuffix(strings.Trim(name, "/"), ".git")
+	name = strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-' {
+			return r
+		}
+		return '-'
+	}, name)
+	return dataHome + "/hm/sources/" + name
 }
 
 func (c *Configuration) Display() {
 	cli_args := "cli args"
+	c.Logger.Debug(cli_args, "command", c.Command)
+	c.Logger.Debug(cli_args, "args", c.Args)
+	c.Logger.Debug(cli_args, "from", c.InitFrom)
+	c.Logger.Debug(cli_args, "output", c.Output)
 	c.Logger.Debug(cli_args, "copy", c.CopyMode)
 	c.Logger.Debug(cli_args, "dbg", c.Debug)
 	c.Logger.Debug(cli_args, "tui", c.Tui)
@@ -44,93 +138,103 @@ func (c *Configuration) Display() {
 	c.Logger.Debug(cli_args, "uninstall", c.Uninstall)
 	c.Logger.Debug(cli_args, "only-uninstall", c.OnlyUninstall)
 	c.Logger.Debug(cli_args, "upgrade", c.Upgrade)
+	c.Logger.Debug(cli_args, "detect-install", c.DetectInstall)
 	c.Logger.Debug(cli_args, "pkgs", c.PkgsTxt)
+	c.Logger.Debug(cli_args, "jobs", c.Jobs)
+	c.Logger.Debug(cli_args, "timeout", c.Timeout)
 	c.Logger.Debug(cli_args, "sourcedir", c.SourceDir)
+	c.Logger.Debug(cli_args, "sourcerepo", c.SourceRepo)
+	c.Logger.Debug(cli_args, "force", c.Force)
+	c.Logger.Debug(cli_args, "failed", c.Failed)
+	c.Logger.Debug(cli_args, "escalate", c.Escalate)
 	c.Logger.Debug(cli_args, "targetdir", c.TargetDir)
 }
 
-func (c *Configuration) AssertCorrectness() {
-	assert((c.OnlyInstall && c.OnlyUninstall) == false, "cannot pass both --only-install and --only-uninstall")
-	assert((c.Install && c.OnlyUninstall) == false, "cannot pass both --install and --only-uninstall")
-	assert((c.OnlyInstall && c.Uninstall) == false, "cannot pass both --only-install and --uninstall")
-	assert((c.Install && c.Upgrade) == false, "cannot pass both --install and --upgrade flags")
-	assert((c.OnlyInstall && c.Upgrade) == false, "cannot pass both --only-install and --upgrade flags")
-	assert((c.Uninstall && c.Upgrade) == false, "cannot pass both --uninstall and --upgrade flags")
-	assert((c.OnlyUninstall && c.Upgrade) == false, "cannot pass both --only-uninstall and --upgrade flags")
-}
-
-func assert(condition bool, message string) {
-	if !condition {
-		panic(message)
+// Parse reads the command and its flags from args (without the program name),
+// flag.ErrHelp is returned when help was requested and UsageError when the
+// command line is invalid
+func Parse(args []string) (Configuration, error) {
+	c := Configuration{HomeDir: os.Getenv("HOME")}
+
+	// NOTE: the flag package stops parsing at the first non flag argument, so
+	// the subcommand has to be taken out before parsing, without one hm
+	// behaves like before subcommands existed
+	name := ApplyCmd
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name = args[0]
+		args = args[1:]
+	}
+	cmd := findCommand(name)
+	if cmd == nil {
+		return c, UsageError{"", "unknown command '" + name + "'"}
+	}
+	c.Command = name
+
+	extra := extraFlags{}
+	fs := newFlagSet(cmd, &c, &extra)
+	fs.SetOutput(io.Discard)
+	err := fs.Parse(args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return c, err
+		}
+		return c, UsageError{name, err.Error()}
+	}
+	c.Args = fs.Args()
+
+	// NOTE: precedence is flag > env > settings file > default
+	c.SettingsPath = SettingsPath(c.HomeDir)
+	c.ProfilePath = ProfilePath(c.HomeDir)
+	fileSettings, err := readSettings(c.SettingsPath)
+	if err != nil {
+		return c, err
+	}
+	known := newFlagSet(findCommand(ConfigCmd), &Configuration{}, &extraFlags{})
+	err = checkSettingKeys(known, fileSettings, c.SettingsPath)
+	if err != nil {
+		return c, err
+	}
+	c.Settings, err = applySettings(fs, fileSettings, c.SettingsPath)
+	if err != nil {
+		return c, err
 	}
-}
-
-func Parse() Configuration {
-	var homeDir = os.Getenv("HOME")
-	copyMode := flag.Bool("copy", false, "copies the config files instead of symlinking them")
-	debug := flag.Bool("dbg", false, "set logging level to debug")
-
-	tui := flag.Bool("tui", false, "run the configuration manager in a TUI")
-
-	// TODO: think if this is something that should be done at all times, or not
-	// saveLockDiff := flag.Bool("save-diff", false, "wheter to save lockfile diff from before and after to a file regardless of the --debug flag")
-	manage := flag.Bool("manage", false, "whether to install and uninstall packages using INSTALL and UNINSTALL instructions (this flag is like passing --install and --uninstall at the same time)")
-
-	install := flag.Bool("install", false, "whether to install packages using INSTALL instructions found in config folders")
-	onlyInstall := flag.Bool("only-install", false, "doesnt copy configs over, only installs the packages that would be copied over based on their INSTALL instructions, --install can be omitted if this option is used")
-
-	uninstall := flag.Bool("uninstall", false, "whether to uninstall packages using INSTALL instructions found in config folders")
-	onlyUninstall := flag.Bool("only-uninstall", false, "doesnt copy configs over, only uninstalls the packages for configs that would be removed based on their instructions, --uninstall can be omitted if this option is used")
-
-	upgrade := flag.Bool("upgrade", false, "whether to upgrade already installed packages, for now simply reruns the original install instruction")
-
-	pkgsTxt := flag.String("pkgs", "", "installs/uninstalls only the packages specified by this argument, empty means work on all active, non-hidden configs, example: --pkgs fish,ghostty")
-
-	sourcedir := flag.String("sourcedir", homeDir+"/.config/homecfg", "source of configuration files, without the trailing /")
-	// TODO: UNCOMMENT AFTER FINISHING TESTING
-	targetDirDefault := homeDir + "/.config"
-	// targetDirDefault := homeDir + "/.configbkp"
-	targetdir := flag.String("targetdir", targetDirDefault, "target for symlinks for debugging, without the trailing /")
-	flag.Parse()
 
-	if *manage {
-		*install = true
-		*uninstall = true
+	err = cmd.Finish(&c, &extra)
+	if err != nil {
+		return c, err
 	}
 
-	defaultIndent := "    "
+	c.finish()
+	return c, nil
+}
+
+// derives the rest of the configuration from the parsed flags
+func (c *Configuration) finish() {
 	var level = slog.LevelInfo
 	var opts = slog.HandlerOptions{Level: &level}
-	logger := slog.New(slog.NewTextHandler(os.Stdout, &opts))
-	if *debug {
+	// NOTE: stdout is reserved for the report when it's meant to be parsed
+	logOutput := os.Stdout
+	if c.Output == OutputJson {
+		logOutput = os.Stderr
+	}
+	c.Logger = slog.New(slog.NewTextHandler(logOutput, &opts))
+	if c.Debug {
 		slog.SetLogLoggerLevel(slog.LevelDebug)
 		level = slog.LevelDebug
 	}
 
-	pkgs := []string{}
-	if *pkgsTxt != "" {
-		pkgs = strings.Split(*pkgsTxt, ",")
+	if IsGitUrl(c.SourceDir) {
+		c.SourceRepo = c.SourceDir
+		c.SourceDir = ManagedSourceDir(c.HomeDir, c.SourceRepo)
 	}
 
-	return Configuration{
-		CopyMode:      *copyMode,
-		Debug:         *debug,
-		Tui:           *tui,
-		Install:       *install,
-		OnlyInstall:   *onlyInstall,
-		Uninstall:     *uninstall,
-		OnlyUninstall: *onlyUninstall,
-		Upgrade:       *upgrade,
-		PkgsTxt:       *pkgsTxt,
-		SourceDir:     *sourcedir,
-		TargetDir:     *targetdir,
-
-		Pkgs:             pkgs,
-		SourceCfgDir:     *sourcedir + "/config",
-		LockfilePath:     *targetdir + "/hmlock.json",
-		LockfileDiffPath: *targetdir + "/hmlock_diff.json",
-		HomeDir:          homeDir,
-		Logger:           logger,
-		DefaultIndent:    defaultIndent,
+	c.Pkgs = []string{}
+	if c.PkgsTxt != "" {
+		c.Pkgs = strings.Split(c.PkgsTxt, ",")
 	}
+
+	c.SourceCfgDir = c.SourceDir + "/config"
+	c.LockfilePath = c.TargetDir + "/hmlock.json"
+	c.LockfileDiffPath = c.TargetDir + "/hmlock_diff.json"
+	c.LogsDir = LogsDir(c.HomeDir)
 }
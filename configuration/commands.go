@@ -0,0 +1,416 @@
+package configuration
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	ApplyCmd     = "apply"
+	InstallCmd   = "install"
+	UninstallCmd = "uninstall"
+	UpgradeCmd   = "upgrade"
+	TuiCmd       = "tui"
+	StatusCmd    = "status"
+	OrphansCmd   = "orphans"
+	AdoptCmd     = "adopt"
+	InitCmd      = "init"
+	ConfigCmd    = "config"
+	DoctorCmd    = "doctor"
+	LogsCmd      = "logs"
+	HelpCmd      = "help"
+)
+
+const (
+	OutputText = "text"
+	OutputJson = "json"
+)
+
+// UsageError is returned for invalid command lines, hm prints it together
+// with a hint where to find help and exits with code 2
+type UsageError struct {
+	Command string
+	Msg     string
+}
+
+func (e UsageError) Error() string {
+	if e.Command == "" {
+		return e.Msg
+	}
+	return e.Command + ": " + e.Msg
+}
+
+// flags that don't map directly to a field of the configuration
+type extraFlags struct {
+	Manage bool
+}
+
+type command struct {
+	Name string
+	// positional arguments shown in the usage, e.g. `<path>...`
+	ArgsUsage string
+	Summary   string
+	// registers flags of the command, common flags are added to every command
+	Flags func(fs *flag.FlagSet, c *Configuration, extra *extraFlags)
+	// validates the parsed flags and arguments and derives the rest of the
+	// configuration from them
+	Finish func(c *Configuration, extra *extraFlags) error
+}
+
+var commands []command
+
+// NOTE: commands are assigned in init, because some of them look up other
+// commands, which would be an initialization cycle otherwise
+func init() {
+	commands = []command{
+		{
+			Name:    ApplyCmd,
+			Summary: "copy/symlink configs and optionally manage their packages, also used when no command is given",
+			Flags: func(fs *flag.FlagSet, c *Configuration, extra *extraFlags) {
+				deployFlags(fs, c, extra)
+				outputFlag(fs, c, extra)
+				fs.BoolVar(&c.Tui, "tui", false, "run the configuration manager in a TUI (same as `hm tui`)")
+			},
+			Finish: func(c *Configuration, extra *extraFlags) error {
+				err := checkOutput(c)
+				if err != nil {
+					return err
+				}
+				if c.Tui && c.Output == OutputJson {
+					return UsageError{c.Command, "cannot pass both --tui and --output json"}
+				}
+				return finishDeploy(c, extra)
+			},
+		},
+		{
+			Name:    InstallCmd,
+			Summary: "install packages of active configs without copying/symlinking them",
+			Flags: func(fs *flag.FlagSet, c *Configuration, extra *extraFlags) {
+				runFlags(fs, c, extra)
+				fs.BoolVar(&c.Failed, "failed", false, "install only the configs which packages failed to install during the last run, packages that failed to upgrade are upgraded again")
+			},
+			Finish: func(c *Configuration, extra *extraFlags) error {
+				c.Install, c.OnlyInstall = true, true
+				return finishRun(c)
+			},
+		},
+		{
+			Name:    UninstallCmd,
+			Summary: "uninstall packages of hidden configs without removing them from the target directory",
+			Flags:   runFlags,
+			Finish: func(c *Configuration, extra *extraFlags) error {
+				c.Uninstall, c.OnlyUninstall = true, true
+				return finishRun(c)
+			},
+		},
+		{
+			Name:    UpgradeCmd,
+			Summary: "upgrade already installed packages without copying/symlinking configs",
+			Flags:   runFlags,
+			Finish: func(c *Configuration, extra *extraFlags) error {
+				// NOTE: only install skips deploying the configs, packages are
+				// upgraded (and the missing ones installed) because of upgrade
+				c.Upgrade, c.OnlyInstall = true, true
+				return finishRun(c)
+			},
+		},
+		{
+			Name:    TuiCmd,
+			Summary: "select configs, global dependencies and flags interactively",
+			Flags:   deployFlags,
+			Finish: func(c *Configuration, extra *extraFlags) error {
+				c.Tui = true
+				return finishDeploy(c, extra)
+			},
+		},
+		{
+			Name:    StatusCmd,
+			Summary: "show installed packages, their versions and whether they satisfy the pins",
+			Finish: func(c *Configuration, extra *extraFlags) error {
+				return noArgs(c)
+			},
+		},
+		{
+			Name:    OrphansCmd,
+			Summary: "list packages installed by hm that no config requires anymore",
+			Flags: func(fs *flag.FlagSet, c *Configuration, extra *extraFlags) {
+				fs.BoolVar(&c.RemoveOrphans, "remove", false, "uninstall the orphaned packages, can't be set in the settings file or the environment")
+			},
+			Finish: func(c *Configuration, extra *extraFlags) error {
+				return noArgs(c)
+			},
+		},
+		{
+			Name:      AdoptCmd,
+			ArgsUsage: "<path>...",
+			Summary:   "move existing configs from the target directory into the source directory",
+			Flags: func(fs *flag.FlagSet, c *Configuration, extra *extraFlags) {
+				fs.BoolVar(&c.CopyMode, "copy", false, "copy the adopted configs back instead of symlinking them")
+				fs.BoolVar(&c.DetectInstall, "detect-install", false, "generate INSTALL for adopted configs based on the package manager owning the binary with the same name")
+			},
+			Finish: func(c *Configuration, extra *extraFlags) error {
+				if len(c.Args) == 0 {
+					return UsageError{c.Command, "needs at least one path"}
+				}
+				return nil
+			},
+		},
+		{
+			Name:    InitCmd,
+			Summary: "create a new source directory",
+			Flags: func(fs *flag.FlagSet, c *Configuration, extra *extraFlags) {
+				fs.StringVar(&c.InitFrom, "from", "", "git url or path of an existing source directory to start from")
+				fs.BoolVar(&c.CopyMode, "copy", false, "write copy mode into the generated settings")
+			},
+			Finish: func(c *Configuration, extra *extraFlags) error {
+				return noArgs(c)
+			},
+		},
+		{
+			Name:      ConfigCmd,
+			ArgsUsage: "show",
+			Summary:   "print the effective configuration and where each value came from",
+			// NOTE: every flag is accepted, so that all the settings can be shown
+			Flags: allFlags,
+			Finish: func(c *Configuration, extra *extraFlags) error {
+				if len(c.Args) != 1 || c.Args[0] != "show" {
+					return UsageError{c.Command, "expected `hm config show`"}
+				}
+				return nil
+			},
+		},
+		{
+			Name:    DoctorCmd,
+			Summary: "check instructions, installation methods, the target directory and the lockfile for problems",
+			Flags:   outputFlag,
+			Finish: func(c *Configuration, extra *extraFlags) error {
+				return finishReport(c)
+			},
+		},
+		{
+			Name:      LogsCmd,
+			ArgsUsage: "[config]",
+			Summary:   "show output of the commands run during the last run, for all configs or just one",
+			Finish: func(c *Configuration, extra *extraFlags) error {
+				if len(c.Args) > 1 {
+					return UsageError{c.Command, "expected at most one config"}
+				}
+				return nil
+			},
+		},
+		{
+			Name:      HelpCmd,
+			ArgsUsage: "[command]",
+			Summary:   "show help for hm or one of its commands",
+			Finish: func(c *Configuration, extra *extraFlags) error {
+				if len(c.Args) > 1 {
+					return UsageError{c.Command, "expected at most one command"}
+				}
+				if len(c.Args) == 1 && findCommand(c.Args[0]) == nil {
+					return UsageError{c.Command, "unknown command '" + c.Args[0] + "'"}
+				}
+				return nil
+			},
+		},
+	}
+}
+
+func findCommand(name string) *command {
+	for idx := range commands {
+		if commands[idx].Name == name {
+			return &commands[idx]
+		}
+	}
+	return nil
+}
+
+func commonFlags(fs *flag.FlagSet, c *Configuration) {
+	fs.StringVar(&c.SourceDir, "sourcedir", c.HomeDir+"/.config/homecfg", "source of configuration files, without the trailing /, can be a git url, which is then cloned into a directory managed by hm")
+	// TODO: UNCOMMENT AFTER FINISHING TESTING
+	targetDirDefault := c.HomeDir + "/.config"
+	// targetDirDefault := homeDir + "/.configbkp"
+	fs.StringVar(&c.TargetDir, "targetdir", targetDirDefault, "target for symlinks for debugging, without the trailing /")
+	fs.BoolVar(&c.Debug, "dbg", false, "set logging level to debug")
+	fs.BoolVar(&c.Force, "force", false, "run even if the source repository has uncommitted changes or diverged from its remote")
+	fs.StringVar(&c.DefaultIndent, "indent", "    ", "indentation used in the lockfile, empty means no pretty printing")
+	fs.StringVar(&c.Escalate, "escalate", "", "tool used to run system package managers as root, one of sudo, doas, run0 or none (hm already runs as root), empty means the first one available")
+}
+
+func pkgsFlag(fs *flag.FlagSet, c *Configuration, extra *extraFlags) {
+	fs.StringVar(&c.PkgsTxt, "pkgs", "", "installs/uninstalls only the packages specified by this argument, empty means work on all active, non-hidden configs, example: --pkgs fish,ghostty")
+}
+
+func jobsFlag(fs *flag.FlagSet, c *Configuration, extra *extraFlags) {
+	fs.IntVar(&c.Jobs, "jobs", 1, "number of configs installed or upgraded at the same time, output of their commands is prefixed with the config name")
+}
+
+func timeoutFlag(fs *flag.FlagSet, c *Configuration, extra *extraFlags) {
+	fs.DurationVar(&c.Timeout, "timeout", 0, "interrupt a command (e.g. of a package manager) that runs longer than this, e.g. 10m, 0 means no timeout")
+}
+
+// validates --jobs and --timeout
+func checkRunLimits(c *Configuration) error {
+	if c.Jobs < 1 {
+		return UsageError{c.Command, fmt.Sprintf("--jobs must be at least 1, got %d", c.Jobs)}
+	}
+	if c.Timeout < 0 {
+		return UsageError{c.Command, fmt.Sprintf("--timeout can't be negative, got %s", c.Timeout)}
+	}
+	return nil
+}
+
+func outputFlag(fs *flag.FlagSet, c *Configuration, extra *extraFlags) {
+	fs.StringVar(&c.Output, "output", OutputText, "format of the report, either text or json, with json the report is printed to stdout and everything else to stderr")
+}
+
+// flags of the commands which install or uninstall packages without deploying
+func runFlags(fs *flag.FlagSet, c *Configuration, extra *extraFlags) {
+	pkgsFlag(fs, c, extra)
+	jobsFlag(fs, c, extra)
+	timeoutFlag(fs, c, extra)
+	outputFlag(fs, c, extra)
+}
+
+// validates flags of install, uninstall and upgrade
+func finishRun(c *Configuration) error {
+	err := checkRunLimits(c)
+	if err != nil {
+		return err
+	}
+	return finishReport(c)
+}
+
+// validates flags of the commands which print a report
+func finishReport(c *Configuration) error {
+	err := checkOutput(c)
+	if err != nil {
+		return err
+	}
+	return noArgs(c)
+}
+
+func checkOutput(c *Configuration) error {
+	if c.Output != OutputText && c.Output != OutputJson {
+		return UsageError{c.Command, fmt.Sprintf("--output must be either %s or %s, got '%s'", OutputText, OutputJson, c.Output)}
+	}
+	return nil
+}
+
+// flags of `hm apply` (and the flags hm had before subcommands existed)
+func deployFlags(fs *flag.FlagSet, c *Configuration, extra *extraFlags) {
+	fs.BoolVar(&c.CopyMode, "copy", false, "copies the config files instead of symlinking them")
+
+	// TODO: think if this is something that should be done at all times, or not
+	// saveLockDiff := flag.Bool("save-diff", false, "wheter to save lockfile diff from before and after to a file regardless of the --debug flag")
+	fs.BoolVar(&extra.Manage, "manage", false, "whether to install and uninstall packages using INSTALL and UNINSTALL instructions (this flag is like passing --install and --uninstall at the same time)")
+
+	fs.BoolVar(&c.Install, "install", false, "whether to install packages using INSTALL instructions found in config folders")
+	fs.BoolVar(&c.OnlyInstall, "only-install", false, "doesnt copy configs over, only installs the packages that would be copied over based on their INSTALL instructions, --install can be omitted if this option is used (same as `hm install`)")
+
+	fs.BoolVar(&c.Uninstall, "uninstall", false, "whether to uninstall packages using INSTALL instructions found in config folders")
+	fs.BoolVar(&c.OnlyUninstall, "only-uninstall", false, "doesnt copy configs over, only uninstalls the packages for configs that would be removed based on their instructions, --uninstall can be omitted if this option is used (same as `hm uninstall`)")
+
+	fs.BoolVar(&c.Upgrade, "upgrade", false, "whether to upgrade already installed packages, for now simply reruns the original install instruction")
+
+	pkgsFlag(fs, c, extra)
+	jobsFlag(fs, c, extra)
+	timeoutFlag(fs, c, extra)
+}
+
+func finishDeploy(c *Configuration, extra *extraFlags) error {
+	err := checkRunLimits(c)
+	if err != nil {
+		return err
+	}
+	if extra.Manage {
+		c.Install = true
+		c.Uninstall = true
+	}
+
+	conflicts := []struct {
+		a, b         bool
+		nameA, nameB string
+	}{
+		{c.OnlyInstall, c.OnlyUninstall, "--only-install", "--only-uninstall"},
+		{c.Install, c.OnlyUninstall, "--install", "--only-uninstall"},
+		{c.OnlyInstall, c.Uninstall, "--only-install", "--uninstall"},
+		{c.Install, c.Upgrade, "--install", "--upgrade"},
+		{c.OnlyInstall, c.Upgrade, "--only-install", "--upgrade"},
+		{c.Uninstall, c.Upgrade, "--uninstall", "--upgrade"},
+		{c.OnlyUninstall, c.Upgrade, "--only-uninstall", "--upgrade"},
+	}
+	for _, conflict := range conflicts {
+		if conflict.a && conflict.b {
+			return UsageError{c.Command, fmt.Sprintf("cannot pass both %s and %s", conflict.nameA, conflict.nameB)}
+		}
+	}
+	return noArgs(c)
+}
+
+func noArgs(c *Configuration) error {
+	if len(c.Args) > 0 {
+		return UsageError{c.Command, fmt.Sprintf("unexpected arguments: %s", strings.Join(c.Args, " "))}
+	}
+	return nil
+}
+
+// registers flags of every command, each name only once
+func allFlags(fs *flag.FlagSet, c *Configuration, extra *extraFlags) {
+	for _, cmd := range commands {
+		if cmd.Flags == nil || cmd.Name == ConfigCmd {
+			continue
+		}
+		cmdFs := flag.NewFlagSet(cmd.Name, flag.ContinueOnError)
+		cmd.Flags(cmdFs, c, extra)
+		cmdFs.VisitAll(func(f *flag.Flag) {
+			if fs.Lookup(f.Name) == nil {
+				fs.Var(f.Value, f.Name, f.Usage)
+			}
+		})
+	}
+}
+
+func newFlagSet(cmd *command, c *Configuration, extra *extraFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet("hm "+cmd.Name, flag.ContinueOnError)
+	commonFlags(fs, c)
+	if cmd.Flags != nil {
+		cmd.Flags(fs, c, extra)
+	}
+	fs.Usage = func() {
+		printCommandUsage(fs.Output(), cmd, fs)
+	}
+	return fs
+}
+
+func printCommandUsage(w io.Writer, cmd *command, fs *flag.FlagSet) {
+	usage := "hm " + cmd.Name + " [flags]"
+	if cmd.ArgsUsage != "" {
+		usage += " " + cmd.ArgsUsage
+	}
+	fmt.Fprintf(w, "usage: %s\n\n%s\n\nflags:\n", usage, cmd.Summary)
+	fs.PrintDefaults()
+}
+
+// PrintUsage prints the list of commands
+func PrintUsage(w io.Writer) {
+	fmt.Fprintf(w, "usage: hm [command] [flags] [args]\n\ncommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-10s %s\n", cmd.Name, cmd.Summary)
+	}
+	fmt.Fprintf(w, "\nrun `hm help <command>` to see the flags of a command, flags have to be passed before the arguments\n")
+}
+
+// PrintCommandUsage prints the usage and flags of a single command
+func PrintCommandUsage(w io.Writer, name string) {
+	cmd := findCommand(name)
+	if cmd == nil {
+		PrintUsage(w)
+		return
+	}
+	c := Configuration{}
+	fs := newFlagSet(cmd, &c, &extraFlags{})
+	fs.SetOutput(w)
+	printCommandUsage(w, cmd, fs)
+}
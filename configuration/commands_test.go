@@ -0,0 +1,101 @@
+package configuration
+
+import (
+	"errors"
+	"flag"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func parseForTest(t *testing.T, args ...string) (Configuration, error) {
+	t.Setenv("HOME", "/home/me")
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("XDG_DATA_HOME", "/home/me/.local/share")
+	return Parse(args)
+}
+
+func TestParseLegacyFlags(t *testing.T) {
+	c, err := parseForTest(t, "--manage", "--copy")
+
+	assert.NoError(t, err)
+	assert.Equal(t, ApplyCmd, c.Command)
+	assert.True(t, c.Install)
+	assert.True(t, c.Uninstall)
+	assert.True(t, c.CopyMode)
+	assert.Equal(t, "/home/me/.config/homecfg/config", c.SourceCfgDir)
+	assert.Equal(t, "/home/me/.config/hmlock.json", c.LockfilePath)
+}
+
+func TestParseSubcommands(t *testing.T) {
+	c, err := parseForTest(t, "upgrade", "--pkgs", "fish,nvim")
+	assert.NoError(t, err)
+	assert.True(t, c.Upgrade)
+	assert.True(t, c.OnlyInstall)
+	assert.Equal(t, []string{"fish", "nvim"}, c.Pkgs)
+
+	c, err = parseForTest(t, "uninstall")
+	assert.NoError(t, err)
+	assert.True(t, c.OnlyUninstall)
+
+	c, err = parseForTest(t, "tui", "--install")
+	assert.NoError(t, err)
+	assert.True(t, c.Tui)
+	assert.True(t, c.Install)
+
+	c, err = parseForTest(t, "adopt", "--detect-install", "/home/me/.config/nvim")
+	assert.NoError(t, err)
+	assert.True(t, c.DetectInstall)
+	assert.Equal(t, []string{"/home/me/.config/nvim"}, c.Args)
+
+	c, err = parseForTest(t, "upgrade", "--jobs", "4", "--timeout", "10m")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, c.Jobs)
+	assert.Equal(t, 10*time.Minute, c.Timeout)
+
+	c, err = parseForTest(t, "install", "--failed")
+	assert.NoError(t, err)
+	assert.True(t, c.Failed)
+	assert.True(t, c.OnlyInstall)
+
+	c, err = parseForTest(t, "doctor", "--output", "json")
+	assert.NoError(t, err)
+	assert.Equal(t, OutputJson, c.Output)
+
+	c, err = parseForTest(t, "status", "--sourcedir", "https://example.com/me/dotfiles.git")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/me/dotfiles.git", c.SourceRepo)
+	assert.Equal(t, "/home/me/.local/share/hm/sources/example.com-me-dotfiles", c.SourceDir)
+}
+
+func TestParseUsageErrors(t *testing.T) {
+	for _, args := range [][]string{
+		{"bogus"},
+		{"--install", "--upgrade"},
+		{"--only-install", "--only-uninstall"},
+		{"install", "extra"},
+		{"status", "--copy"},
+		{"adopt"},
+		{"config"},
+		{"help", "bogus"},
+		{"doctor", "--output", "yaml"},
+		{"install", "--output", "xml"},
+		{"--tui", "--output", "json"},
+		{"install", "--jobs", "0"},
+		{"install", "--timeout", "-1s"},
+		{"upgrade", "--failed"},
+		{"doctor", "--jobs", "2"},
+		{"logs", "fish", "nvim"},
+	} {
+		_, err := parseForTest(t, args...)
+		var usageErr UsageError
+		assert.ErrorAs(t, err, &usageErr, args)
+	}
+}
+
+func TestParseHelp(t *testing.T) {
+	_, err := parseForTest(t, "install", "-h")
+
+	assert.True(t, errors.Is(err, flag.ErrHelp))
+}
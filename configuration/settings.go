@@ -0,0 +1,197 @@
+package configuration
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// where the effective value of a setting came from
+type Source string
+
+const (
+	SourceFlag     Source = "flag"
+	SourceEnv      Source = "env"
+	SourceFile     Source = "settings file"
+	SourceDefault  Source = "default"
+	ENV_VAR_PREFIX        = "HM_"
+)
+
+type Setting struct {
+	Name   string
+	Value  string
+	Source Source
+}
+
+// EnvVarName is the environment variable for a flag, e.g. `HM_SOURCEDIR` for
+// `--sourcedir` or `HM_ONLY_INSTALL` for `--only-install`
+func EnvVarName(flagName string) string {
+	return ENV_VAR_PREFIX + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// flags that do something destructive for a single run, they can only be
+// passed on the command line
+var commandLineOnly = []string{"remove"}
+
+// checkSettingKeys makes sure every key of the settings file is a flag of at
+// least one command
+func checkSettingKeys(known *flag.FlagSet, fileSettings map[string]string, settingsPath string) error {
+	for key := range fileSettings {
+		if known.Lookup(key) == nil {
+			return fmt.Errorf("%s: unknown setting '%s'", settingsPath, key)
+		}
+		if slices.Contains(commandLineOnly, key) {
+			return fmt.Errorf("%s: '%s' can only be passed on the command line", settingsPath, key)
+		}
+	}
+	return nil
+}
+
+// applySettings fills in flags that weren't passed on the command line, first
+// from HM_* environment variables and then from the settings file, every flag
+// (except commandLineOnly ones) can be set this way under the same name
+func applySettings(flags *flag.FlagSet, fileSettings map[string]string, settingsPath string) ([]Setting, error) {
+	passed := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) {
+		passed[f.Name] = true
+	})
+
+	settings := []Setting{}
+	errs := []string{}
+	flags.VisitAll(func(f *flag.Flag) {
+		source := SourceDefault
+		if passed[f.Name] {
+			source = SourceFlag
+		} else if slices.Contains(commandLineOnly, f.Name) {
+			// NOTE: stays the default
+		} else if value := os.Getenv(EnvVarName(f.Name)); value != "" {
+			source = SourceEnv
+			if err := f.Value.Set(value); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s", EnvVarName(f.Name), err))
+			}
+		} else if value, ok := fileSettings[f.Name]; ok {
+			source = SourceFile
+			if err := f.Value.Set(value); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s: %s", settingsPath, f.Name, err))
+			}
+		}
+		settings = append(settings, Setting{Name: f.Name, Value: f.Value.String(), Source: source})
+	})
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid settings: %s", strings.Join(errs, ", "))
+	}
+	return settings, nil
+}
+
+func readSettings(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		// NOTE: settings file is optional
+		if os.IsNotExist(err) {
+			return map[string]string{}, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	settings, err := parseSettings(file)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse '%s': %w", path, err)
+	}
+	return settings, nil
+}
+
+// parseSettings understands a flat subset of TOML: `key = value` pairs where
+// the value is a string ("..." or '...'), a boolean or a number, tables and
+// arrays are not supported
+func parseSettings(r io.Reader) (map[string]string, error) {
+	settings := map[string]string{}
+
+	scanner := bufio.NewScanner(r)
+	lineNr := 0
+	for scanner.Scan() {
+		lineNr++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			return nil, fmt.Errorf("line %d: tables are not supported", lineNr)
+		}
+
+		key, value, found := strings.Cut(line, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !found || key == "" {
+			return nil, fmt.Errorf("line %d: expected key = value, got '%s'", lineNr, line)
+		}
+		if _, ok := settings[key]; ok {
+			return nil, fmt.Errorf("line %d: '%s' is set more than once", lineNr, key)
+		}
+
+		parsed, err := parseSettingValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNr, err)
+		}
+		settings[key] = parsed
+	}
+
+	return settings, scanner.Err()
+}
+
+func parseSettingValue(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, `"`):
+		end := closingQuote(value)
+		if end == -1 {
+			return "", fmt.Errorf("unterminated string %s", value)
+		}
+		if err := onlyComment(value[end+1:]); err != nil {
+			return "", err
+		}
+		return strconv.Unquote(value[:end+1])
+	case strings.HasPrefix(value, "'"):
+		// NOTE: literal strings don't have escapes
+		end := strings.Index(value[1:], "'")
+		if end == -1 {
+			return "", fmt.Errorf("unterminated string %s", value)
+		}
+		if err := onlyComment(value[end+2:]); err != nil {
+			return "", err
+		}
+		return value[1 : end+1], nil
+	default:
+		value, _, _ = strings.Cut(value, "#")
+		value = strings.TrimSpace(value)
+		if value == "" || strings.ContainsAny(value, " \t[{") {
+			return "", fmt.Errorf("unsupported value '%s', strings have to be quoted", value)
+		}
+		return value, nil
+	}
+}
+
+// index of the quote closing a basic string, skipping escaped ones
+func closingQuote(value string) int {
+	for idx := 1; idx < len(value); idx++ {
+		switch value[idx] {
+		case '\\':
+			idx++
+		case '"':
+			return idx
+		}
+	}
+	return -1
+}
+
+func onlyComment(rest string) error {
+	rest = strings.TrimSpace(rest)
+	if rest != "" && !strings.HasPrefix(rest, "#") {
+		return fmt.Errorf("unexpected '%s' after the value", rest)
+	}
+	return nil
+}
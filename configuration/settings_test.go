@@ -0,0 +1,113 @@
+package configuration
+
+import (
+	"flag"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSettings(t *testing.T) {
+	txt := `# hm settings
+sourcedir = "/home/me/dotfiles" # trailing comment
+targetdir = '/home/me/.config'
+indent = "\t"
+copy = true
+`
+	settings, err := parseSettings(strings.NewReader(txt))
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"sourcedir": "/home/me/dotfiles",
+		"targetdir": "/home/me/.config",
+		"indent":    "\t",
+		"copy":      "true",
+	}, settings)
+}
+
+func TestParseSettingsErrors(t *testing.T) {
+	for _, txt := range []string{
+		"[hm]\n",
+		"sourcedir\n",
+		`sourcedir = "/unterminated` + "\n",
+		"sourcedir = /not/quoted dir\n",
+		"copy = true\ncopy = false\n",
+	} {
+		_, err := parseSettings(strings.NewReader(txt))
+		assert.Error(t, err, txt)
+	}
+}
+
+func TestApplySettingsPrecedence(t *testing.T) {
+	path := t.TempDir() + "/settings.toml"
+	assert.NoError(t, os.WriteFile(path, []byte("sourcedir = \"/from/file\"\ntargetdir = \"/from/file\"\ncopy = true\n"), 0644))
+	t.Setenv("HM_TARGETDIR", "/from/env")
+	t.Setenv("HM_COPY", "false")
+
+	flags := flag.NewFlagSet("hm", flag.ContinueOnError)
+	sourcedir := flags.String("sourcedir", "/default", "")
+	targetdir := flags.String("targetdir", "/default", "")
+	copyMode := flags.Bool("copy", false, "")
+	pkgs := flags.String("pkgs", "", "")
+	assert.NoError(t, flags.Parse([]string{"--copy"}))
+
+	fileSettings, err := readSettings(path)
+	assert.NoError(t, err)
+	settings, err := applySettings(flags, fileSettings, path)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "/from/file", *sourcedir)
+	assert.Equal(t, "/from/env", *targetdir)
+	assert.True(t, *copyMode)
+	assert.Equal(t, "", *pkgs)
+	assert.Equal(t, []Setting{
+		{Name: "copy", Value: "true", Source: SourceFlag},
+		{Name: "pkgs", Value: "", Source: SourceDefault},
+		{Name: "sourcedir", Value: "/from/file", Source: SourceFile},
+		{Name: "targetdir", Value: "/from/env", Source: SourceEnv},
+	}, settings)
+}
+
+func TestCheckSettingKeys(t *testing.T) {
+	path := t.TempDir() + "/settings.toml"
+	assert.NoError(t, os.WriteFile(path, []byte("sourcdir = \"/typo\"\n"), 0644))
+	flags := flag.NewFlagSet("hm", flag.ContinueOnError)
+	flags.String("sourcedir", "/default", "")
+	fileSettings, err := readSettings(path)
+	assert.NoError(t, err)
+
+	err = checkSettingKeys(flags, fileSettings, path)
+
+	assert.Error(t, err)
+}
+
+func TestCommandLineOnlyFlagsIgnoreSettings(t *testing.T) {
+	path := t.TempDir() + "/settings.toml"
+	assert.NoError(t, os.WriteFile(path, []byte("remove = true\n"), 0644))
+	t.Setenv("HM_REMOVE", "true")
+	flags := flag.NewFlagSet("hm", flag.ContinueOnError)
+	remove := flags.Bool("remove", false, "")
+	fileSettings, err := readSettings(path)
+	assert.NoError(t, err)
+
+	assert.Error(t, checkSettingKeys(flags, fileSettings, path))
+	settings, err := applySettings(flags, fileSettings, path)
+
+	assert.NoError(t, err)
+	assert.False(t, *remove)
+	assert.Equal(t, []Setting{{Name: "remove", Value: "false", Source: SourceDefault}}, settings)
+}
+
+func TestUninstallSettingDoesntRemoveOrphans(t *testing.T) {
+	t.Setenv("HM_UNINSTALL", "1")
+
+	c, err := parseForTest(t, "orphans")
+	assert.NoError(t, err)
+	assert.False(t, c.RemoveOrphans)
+
+	c, err = parseForTest(t, "orphans", "--remove")
+	assert.NoError(t, err)
+	assert.True(t, c.RemoveOrphans)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	conf "blanktiger/hm/configuration"
+	"blanktiger/hm/lib"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+)
+
+// prints the problems found by lib.Doctor, fails only if some of them are
+// errors, warnings are just reported
+func doctorMain(c *conf.Configuration, initErr error) error {
+	lockBefore, err := readLastLockfile(c)
+	if err != nil {
+		return err
+	}
+
+	findings := lib.Doctor(c, lockBefore, initErr)
+
+	if c.Output == conf.OutputJson {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", c.DefaultIndent)
+		err = encoder.Encode(findings)
+	} else {
+		err = printFindings(findings)
+	}
+	if err != nil {
+		return err
+	}
+
+	if lib.HasErrors(findings) {
+		return errors.New("hm doctor found errors")
+	}
+	return nil
+}
+
+func printFindings(findings []lib.Finding) error {
+	if len(findings) == 0 {
+		fmt.Println("no problems found")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SEVERITY\tCHECK\tCONFIG\tLOCATION\tPROBLEM")
+	for _, f := range findings {
+		cfgName := f.Config
+		if cfgName == "" {
+			cfgName = "-"
+		}
+		location := f.Location
+		if location == "" {
+			location = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", f.Severity, f.Check, cfgName, location, f.Msg)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,143 @@
+package instructions
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const ALIASES_PATH_POSTFIX = "/ALIASES"
+
+// package name -> system package manager -> name of the package for that
+// package manager, used to make `system:` instructions portable between
+// distros
+var pkgAliases = map[string]map[InstallMethod]string{
+	"fd": {
+		Apt: "fd-find",
+		Dnf: "fd-find",
+	},
+	"delta": {
+		Pacman: "git-delta",
+		Apt:    "git-delta",
+		Dnf:    "git-delta",
+		Brew:   "git-delta",
+	},
+	"python": {
+		Apt: "python3",
+		Dnf: "python3",
+	},
+	"pip": {
+		Pacman: "python-pip",
+		Apt:    "python3-pip",
+		Dnf:    "python3-pip",
+	},
+	"base-devel": {
+		Apt:  "build-essential",
+		Dnf:  "@development-tools",
+		Brew: "gcc",
+	},
+	"openssh": {
+		Apt: "openssh-client",
+		Dnf: "openssh-clients",
+	},
+	"7zip": {
+		Pacman: "p7zip",
+		Apt:    "p7zip-full",
+		Dnf:    "p7zip",
+		Brew:   "p7zip",
+	},
+}
+
+// LoadPkgAliases extends the builtin aliases with the ones from the ALIASES
+// file, each line looks like this:
+//
+//	fd apt:fd-find dnf:fd-find
+func LoadPkgAliases(srcDir string) error {
+	path := srcDir + ALIASES_PATH_POSTFIX
+	file, err := os.Open(path)
+	if err != nil {
+		// NOTE: file not existing is not an error in this case (builtin
+		// aliases are used)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	aliases, err := parsePkgAliases(file)
+	if err != nil {
+		return WithPath(err, path)
+	}
+
+	for pkg, perManager := range aliases {
+		if _, ok := pkgAliases[pkg]; !ok {
+			pkgAliases[pkg] = map[InstallMethod]string{}
+		}
+		for manager, alias := range perManager {
+			pkgAliases[pkg][manager] = alias
+		}
+	}
+	return nil
+}
+
+func parsePkgAliases(r io.Reader) (map[string]map[InstallMethod]string, error) {
+	aliases := map[string]map[InstallMethod]string{}
+
+	scanner := bufio.NewScanner(r)
+	lineNr := 0
+	for scanner.Scan() {
+		lineNr++
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, ParseError{Line: lineNr, Text: line, Err: errors.New("expected a package followed by manager:name pairs")}
+		}
+
+		pkg := fields[0]
+		if _, ok := aliases[pkg]; !ok {
+			aliases[pkg] = map[InstallMethod]string{}
+		}
+		for _, field := range fields[1:] {
+			manager, alias, found := strings.Cut(field, ":")
+			if !found || alias == "" {
+				return nil, ParseError{Line: lineNr, Text: field, Err: errors.New("expected manager:name")}
+			}
+			switch InstallMethod(manager) {
+			case Apt, Pacman, Dnf, Brew:
+			default:
+				return nil, ParseError{Line: lineNr, Text: field, Err: fmt.Errorf("'%s' is not a system package manager", manager)}
+			}
+			aliases[pkg][InstallMethod(manager)] = alias
+		}
+	}
+
+	return aliases, scanner.Err()
+}
+
+func resolvePkgAliases(manager InstallMethod, pkg string) string {
+	resolved := []string{}
+	for name := range strings.SplitSeq(pkg, " ") {
+		if alias, ok := pkgAliases[name][manager]; ok {
+			name = alias
+		}
+		resolved = append(resolved, name)
+	}
+	return strings.Join(resolved, " ")
+}
+
+// ResolvePkg translates the package name of `system:` instructions (can be
+// many packages separated by spaces) to the names used by the detected system
+// package manager, other methods get the name back unchanged
+func (m *InstallMethod) ResolvePkg(pkg string) string {
+	if *m != System {
+		return pkg
+	}
+	return resolvePkgAliases(systemPkgManager, pkg)
+}
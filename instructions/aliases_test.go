@@ -0,0 +1,30 @@
+package instructions
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolvePkgAliases(t *testing.T) {
+	assert.Equal(t, "fd-find ripgrep", resolvePkgAliases(Apt, "fd ripgrep"))
+	assert.Equal(t, "fd ripgrep", resolvePkgAliases(Pacman, "fd ripgrep"))
+
+	cargo := Cargo
+	assert.Equal(t, "fd", cargo.ResolvePkg("fd"))
+}
+
+func TestParsePkgAliases(t *testing.T) {
+	txt := "# comment\nneovim apt:neovim-nightly dnf:neovim\n"
+	aliases, err := parsePkgAliases(strings.NewReader(txt))
+
+	assert.NoError(t, err)
+	expected := map[string]map[InstallMethod]string{
+		"neovim": {Apt: "neovim-nightly", Dnf: "neovim"},
+	}
+	assert.Equal(t, expected, aliases)
+
+	_, err = parsePkgAliases(strings.NewReader("neovim cargo:neovim\n"))
+	assert.ErrorContains(t, err, "not a system package manager")
+}
@@ -0,0 +1,206 @@
+package instructions
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const METHODS_PATH_POSTFIX = "/METHODS"
+
+// installation method defined by the user in the METHODS file, e.g.:
+//
+//	[pipx]
+//	install = pipx install {pkg}
+//	uninstall = pipx uninstall {pkg}
+//	upgrade = pipx upgrade {pkg}
+//	check = pipx runpip {pkg} --version
+//	detect = pipx --version
+//	retries = 2
+//	root = false
+//
+// `{pkg}` is replaced by the package and `{<option>}` by the value of an
+// option passed in the instruction, e.g. `pipx(python=3.12):black`
+type CustomMethod struct {
+	Name      string
+	Install   string
+	Uninstall string
+	Upgrade   string
+	Check     string
+	Detect    string
+	// how many times failed install and upgrade commands are retried
+	Retries int
+	// commands are run through the escalation tool (see SetEscalation)
+	Root bool
+
+	// result of running the Detect command during Init, methods without the
+	// Detect command are always considered available
+	Available bool
+}
+
+var customMethods = map[string]*CustomMethod{}
+
+func LoadCustomMethods(srcDir string) error {
+	path := srcDir + METHODS_PATH_POSTFIX
+	file, err := os.Open(path)
+	if err != nil {
+		// NOTE: file not existing is not an error in this case (most users
+		// will be fine with the builtin methods)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	methods, err := parseCustomMethods(file)
+	if err != nil {
+		return WithPath(err, path)
+	}
+
+	for _, method := range methods {
+		method.Available = method.Detect == "" || execSucceeds(method.Detect)
+		Logger.Info("Registered custom installation method", "method", method.Name, "available", method.Available)
+		customMethods[method.Name] = method
+	}
+	return nil
+}
+
+func parseCustomMethods(r io.Reader) ([]*CustomMethod, error) {
+	methods := []*CustomMethod{}
+	seen := map[string]bool{}
+	var current *CustomMethod
+
+	scanner := bufio.NewScanner(r)
+	lineNr := 0
+	for scanner.Scan() {
+		lineNr++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			if IsValidInstallationMethod(name) || seen[name] {
+				return nil, ParseError{Line: lineNr, Text: line, Err: fmt.Errorf("method '%s' is already defined", name)}
+			}
+			if name == "" || strings.ContainsAny(name, ":() ") {
+				return nil, ParseError{Line: lineNr, Text: line, Err: errors.New("invalid method name")}
+			}
+			current = &CustomMethod{Name: name}
+			methods = append(methods, current)
+			seen[name] = true
+			continue
+		}
+
+		if current == nil {
+			return nil, ParseError{Line: lineNr, Text: line, Err: errors.New("expected a [method] header before this line")}
+		}
+
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			return nil, ParseError{Line: lineNr, Text: line, Err: errors.New("expected key = value")}
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+		switch key {
+		case "install":
+			current.Install = value
+		case "uninstall":
+			current.Uninstall = value
+		case "upgrade":
+			current.Upgrade = value
+		case "check":
+			current.Check = value
+		case "detect":
+			current.Detect = value
+		case "retries":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return nil, ParseError{Line: lineNr, Text: line, Err: errors.New("retries must be a non-negative number")}
+			}
+			current.Retries = n
+		case "root":
+			root, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, ParseError{Line: lineNr, Text: line, Err: errors.New("root must be either true or false")}
+			}
+			current.Root = root
+		default:
+			return nil, ParseError{Line: lineNr, Text: line, Err: fmt.Errorf("unknown key '%s'", key)}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, method := range methods {
+		if method.Install == "" {
+			return nil, ParseError{Err: fmt.Errorf("method '%s' is missing the install command", method.Name)}
+		}
+	}
+
+	return methods, nil
+}
+
+func findCustomMethod(method InstallMethod) (*CustomMethod, bool) {
+	m, ok := customMethods[string(method)]
+	return m, ok
+}
+
+// `{pkg}` or `{<option>}` in the commands of custom methods
+var placeholderRe = regexp.MustCompile(`\{[A-Za-z_][A-Za-z0-9_-]*\}`)
+
+var customMethodUnavailableErr = errors.New("custom installation method is not available on this system (detect command failed)")
+
+func expandCustomTemplate(m *CustomMethod, template, kind, pkg string, opts Options) (string, error) {
+	if !m.Available {
+		return "", customMethodUnavailableErr
+	}
+	if template == "" {
+		return "", fmt.Errorf("custom method '%s' doesn't define the %s command", m.Name, kind)
+	}
+
+	missing := []string{}
+	cmd := placeholderRe.ReplaceAllStringFunc(template, func(placeholder string) string {
+		name := placeholder[1 : len(placeholder)-1]
+		if name == "pkg" {
+			return pkg
+		}
+		value, ok := opts[name]
+		if !ok && !slices.Contains(missing, name) {
+			missing = append(missing, name)
+		}
+		return value
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("the %s command of custom method '%s' needs the option(s) %s, pass them like %s(%s=...):%s", kind, m.Name, strings.Join(missing, ", "), m.Name, missing[0], pkg)
+	}
+	return cmd, nil
+}
+
+func (m *CustomMethod) installCmd(pkg string, opts Options) (string, error) {
+	return expandCustomTemplate(m, m.Install, "install", pkg, opts)
+}
+
+func (m *CustomMethod) uninstallCmd(pkg string, opts Options) (string, error) {
+	return expandCustomTemplate(m, m.Uninstall, "uninstall", pkg, opts)
+}
+
+func (m *CustomMethod) upgradeCmd(pkg string, opts Options) (string, error) {
+	if m.Upgrade == "" {
+		return m.installCmd(pkg, opts)
+	}
+	return expandCustomTemplate(m, m.Upgrade, "upgrade", pkg, opts)
+}
+
+func (m *CustomMethod) checkCmd(pkg string, opts Options) (string, error) {
+	return expandCustomTemplate(m, m.Check, "check", pkg, opts)
+}
@@ -0,0 +1,77 @@
+package instructions
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const pipxMethods = `
+# python apps
+[pipx]
+install = pipx install {pkg}
+uninstall = pipx uninstall {pkg}
+upgrade = pipx upgrade {pkg} --python {python}
+`
+
+func TestCustomMethodIsTreatedLikeBuiltin(t *testing.T) {
+	methods, err := parseCustomMethods(strings.NewReader(pipxMethods))
+	assert.NoError(t, err)
+	assert.Len(t, methods, 1)
+	methods[0].Available = true
+	customMethods[methods[0].Name] = methods[0]
+	t.Cleanup(func() { delete(customMethods, "pipx") })
+
+	assert.True(t, IsValidInstallationMethod("pipx"))
+
+	method := InstallMethod("pipx")
+	cmd, err := method.CreateInstallCmd("black", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "pipx install black", cmd)
+
+	cmd, err = method.CreateUpgradeCmd("black", Options{"python": "3.12"})
+	assert.NoError(t, err)
+	assert.Equal(t, "pipx upgrade black --python 3.12", cmd)
+
+	_, err = method.CreateCheckCmd("black", nil)
+	assert.Error(t, err)
+
+	_, err = method.CreateUpgradeCmd("black", nil)
+	assert.ErrorContains(t, err, "needs the option(s) python")
+}
+
+func TestCustomMethodsCannotShadowBuiltins(t *testing.T) {
+	_, err := parseCustomMethods(strings.NewReader("[cargo]\ninstall = cargo install {pkg}\n"))
+	assert.ErrorContains(t, err, "already defined")
+}
+
+func TestCustomMethodMustDefineInstall(t *testing.T) {
+	_, err := parseCustomMethods(strings.NewReader("[pipx]\nuninstall = pipx uninstall {pkg}\n"))
+	assert.ErrorContains(t, err, "missing the install command")
+}
+
+func TestCustomMethodErrorsPointAtTheLine(t *testing.T) {
+	_, err := parseCustomMethods(strings.NewReader("[pipx]\ninstall pipx install {pkg}\n"))
+	err = WithPath(err, "/src/config/METHODS")
+
+	var parseErr ParseError
+	assert.ErrorAs(t, err, &parseErr)
+	assert.Equal(t, 2, parseErr.Line)
+	assert.EqualError(t, err, "/src/config/METHODS:2: expected key = value: 'install pipx install {pkg}'")
+}
+
+func TestCustomMethodRetries(t *testing.T) {
+	methods, err := parseCustomMethods(strings.NewReader("[pipx]\ninstall = pipx install {pkg}\nretries = 3\n"))
+	assert.NoError(t, err)
+	customMethods["pipx"] = methods[0]
+	t.Cleanup(func() { delete(customMethods, "pipx") })
+
+	method := InstallMethod("pipx")
+	retries, err := method.Retries(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, retries)
+
+	_, err = parseCustomMethods(strings.NewReader("[uvx]\ninstall = uv tool install {pkg}\nretries = many\n"))
+	assert.ErrorContains(t, err, "retries must be a non-negative number")
+}
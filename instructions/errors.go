@@ -0,0 +1,59 @@
+package instructions
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ParseError points at the line of a file (INSTALL, DEPENDENCIES, METHODS,
+// ALIASES, the profile) that couldn't be parsed
+type ParseError struct {
+	Path string
+	// starts at 1, 0 if the error isn't about a single line
+	Line int
+	// the offending text, usually the whole line
+	Text string
+	Err  error
+}
+
+func (e ParseError) Error() string {
+	location := e.Path
+	if location == "" {
+		location = "<input>"
+	}
+	if e.Line > 0 {
+		location += fmt.Sprintf(":%d", e.Line)
+	}
+	if e.Text == "" {
+		return fmt.Sprintf("%s: %s", location, e.Err)
+	}
+	return fmt.Sprintf("%s: %s: '%s'", location, e.Err, e.Text)
+}
+
+func (e ParseError) Unwrap() error {
+	return e.Err
+}
+
+// WithPath fills in the path of parse errors returned by parsers that only
+// see a reader, other errors are returned unchanged
+func WithPath(err error, path string) error {
+	var parseErr ParseError
+	if errors.As(err, &parseErr) {
+		parseErr.Path = path
+		return parseErr
+	}
+	return err
+}
+
+// UnknownMethodError is returned for methods that are neither builtin nor
+// defined in the METHODS file
+type UnknownMethodError struct {
+	Method string
+}
+
+func (e UnknownMethodError) Error() string {
+	if e.Method == "" {
+		return "installation method is missing"
+	}
+	return fmt.Sprintf("unknown installation method '%s'", e.Method)
+}
@@ -0,0 +1,119 @@
+package instructions
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Escalation is the tool that runs commands of the methods which need root
+// (see InstallMethod.NeedsRoot)
+type Escalation string
+
+const (
+	EscalateSudo Escalation = "sudo"
+	EscalateDoas Escalation = "doas"
+	EscalateRun0 Escalation = "run0"
+	// hm already runs as root
+	EscalateNone Escalation = "none"
+)
+
+var escalations = []Escalation{EscalateSudo, EscalateDoas, EscalateRun0, EscalateNone}
+
+var escalation = EscalateSudo
+
+// SetEscalation selects the escalation tool by its name, empty name picks
+// EscalateNone for root and the first available tool otherwise
+func SetEscalation(name string) error {
+	if name == "" {
+		escalation = detectEscalation()
+		Logger.Info("Result of search for the privilege escalation tool", "found", escalation)
+		return nil
+	}
+
+	for _, e := range escalations {
+		if string(e) == name {
+			escalation = e
+			return nil
+		}
+	}
+	names := []string{}
+	for _, e := range escalations {
+		names = append(names, string(e))
+	}
+	return fmt.Errorf("unknown privilege escalation tool '%s', expected one of: %s", name, strings.Join(names, ", "))
+}
+
+func detectEscalation() Escalation {
+	if os.Geteuid() == 0 {
+		return EscalateNone
+	}
+	for _, e := range []Escalation{EscalateSudo, EscalateDoas, EscalateRun0} {
+		if cmdAvailable(InstallMethod(e)) {
+			return e
+		}
+	}
+	// NOTE: the commands fail with a clear "not found" error, which is better
+	// than running them without root
+	return EscalateSudo
+}
+
+func CurrentEscalation() Escalation {
+	return escalation
+}
+
+func EscalationAvailable() bool {
+	return escalation == EscalateNone || cmdAvailable(InstallMethod(escalation))
+}
+
+// CredentialsCmd asks for the credentials before anything is run, so that the
+// prompt doesn't get lost in the output of the package managers, empty if the
+// tool doesn't remember them between commands
+func CredentialsCmd() string {
+	if escalation == EscalateSudo {
+		return "sudo -v"
+	}
+	return ""
+}
+
+// RefreshCredentialsCmd keeps the remembered credentials from expiring during
+// long runs, it must not ask for anything
+func RefreshCredentialsCmd() string {
+	if escalation == EscalateSudo {
+		return "sudo -n -v"
+	}
+	return ""
+}
+
+// escalate runs the command through the escalation tool if the method needs
+// root, nothing else is ever escalated
+func (m *InstallMethod) escalate(cmd string) string {
+	if cmd == "" || escalation == EscalateNone || !m.NeedsRoot() {
+		return cmd
+	}
+	return string(escalation) + " " + cmd
+}
+
+// AsksForCredentials reports whether commands of the method may ask for the
+// credentials of the escalation tool, either because they are run through it
+// or because they call it on their own (AUR helpers)
+func (m *InstallMethod) AsksForCredentials() bool {
+	switch *m {
+	case Aur, Yay, Paru, Pacaur, Aurman:
+		return escalation != EscalateNone
+	default:
+		return m.NeedsRoot()
+	}
+}
+
+// flags of the AUR helpers which call the escalation tool on their own
+func aurEscalationArgs() string {
+	switch escalation {
+	case EscalateSudo:
+		return "--sudoloop "
+	case EscalateNone:
+		return ""
+	default:
+		return "--sudo " + string(escalation) + " "
+	}
+}
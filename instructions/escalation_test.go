@@ -0,0 +1,75 @@
+package instructions
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEscalationIsOnlyUsedForRoot(t *testing.T) {
+	t.Cleanup(func() { escalation = EscalateSudo })
+
+	for _, tc := range []struct {
+		escalation string
+		method     InstallMethod
+		expected   string
+	}{
+		{"sudo", Pacman, "sudo pacman -S --noconfirm fish"},
+		{"doas", Pacman, "doas pacman -S --noconfirm fish"},
+		{"run0", Dnf, "run0 dnf install -y fish"},
+		{"none", Apt, "apt install -y fish"},
+		{"doas", Cargo, "cargo install fish"},
+		{"sudo", Yay, "yay -S --sudoloop fish"},
+		{"doas", Paru, "paru -S --sudo doas fish"},
+		{"sudo", Pacaur, "pacaur -S --sudoloop fish"},
+		{"run0", Aurman, "aurman -S --sudo run0 fish"},
+		{"none", Aurman, "aurman -S fish"},
+	} {
+		assert.NoError(t, SetEscalation(tc.escalation))
+		cmd, err := tc.method.CreateInstallCmd("fish", nil)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, cmd, tc)
+	}
+
+	assert.NoError(t, SetEscalation("doas"))
+	snap := Snap
+	cmd, err := snap.CreateUpgradeCmd("code", Options{"classic": "true"})
+	assert.NoError(t, err)
+	assert.Equal(t, "doas snap refresh --classic code", cmd)
+}
+
+func TestAurHelpersAskForCredentials(t *testing.T) {
+	t.Cleanup(func() { escalation = EscalateSudo })
+
+	assert.NoError(t, SetEscalation("sudo"))
+	for _, method := range []InstallMethod{Aur, Yay, Paru, Pacaur, Aurman, Pacman} {
+		assert.True(t, method.AsksForCredentials(), method)
+	}
+	cargo := Cargo
+	assert.False(t, cargo.AsksForCredentials())
+
+	assert.NoError(t, SetEscalation("none"))
+	yay := Yay
+	assert.False(t, yay.AsksForCredentials())
+}
+
+func TestUnknownEscalation(t *testing.T) {
+	err := SetEscalation("su")
+
+	assert.ErrorContains(t, err, "expected one of: sudo, doas, run0, none")
+	assert.Equal(t, EscalateSudo, escalation)
+}
+
+func TestCustomMethodCanNeedRoot(t *testing.T) {
+	methods, err := parseCustomMethods(strings.NewReader("[xbps]\ninstall = xbps-install -y {pkg}\nroot = true\n"))
+	assert.NoError(t, err)
+	methods[0].Available = true
+	customMethods["xbps"] = methods[0]
+	t.Cleanup(func() { delete(customMethods, "xbps") })
+
+	method := InstallMethod("xbps")
+	cmd, err := method.CreateInstallCmd("fish", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "sudo xbps-install -y fish", cmd)
+}
@@ -0,0 +1,160 @@
+package instructions
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// Facts describe the machine hm runs on, they are gathered once in Init and
+// used to evaluate instruction conditions like `[os=darwin] brew:gnu-sed`
+type Facts struct {
+	OS     string `json:"os"`
+	Distro string `json:"distro"`
+	// distros this one is based on (ID_LIKE from /etc/os-release), so that
+	// `distro=debian` matches on ubuntu as well
+	DistroLike []string `json:"distroLike"`
+	Arch       string   `json:"arch"`
+	Hostname   string   `json:"hostname"`
+}
+
+var facts = Facts{}
+
+func CurrentFacts() Facts {
+	return facts
+}
+
+func GatherFacts() {
+	facts.OS = runtime.GOOS
+	facts.Arch = Arch()
+	facts.Distro, facts.DistroLike = readDistro()
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		Logger.Debug("couldn't read the hostname", "err", err)
+	}
+	facts.Hostname = hostname
+
+	Logger.Info("Gathered facts about the machine", "os", facts.OS, "distro", facts.Distro, "arch", facts.Arch, "hostname", facts.Hostname)
+}
+
+// Arch uses the naming from `uname -m` instead of the go one
+func Arch() string {
+	switch runtime.GOARCH {
+	case "amd64":
+		return "x86_64"
+	case "arm64":
+		return "aarch64"
+	default:
+		return runtime.GOARCH
+	}
+}
+
+func readDistro() (string, []string) {
+	if runtime.GOOS == "darwin" {
+		return "macos", []string{}
+	}
+
+	file, err := os.Open("/etc/os-release")
+	if err != nil {
+		Logger.Debug("couldn't read os-release", "err", err)
+		return "", []string{}
+	}
+	defer file.Close()
+
+	id, like := "", []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), "=")
+		if !found {
+			continue
+		}
+		value = strings.Trim(value, `"'`)
+		switch key {
+		case "ID":
+			id = value
+		case "ID_LIKE":
+			like = strings.Fields(value)
+		}
+	}
+	return id, like
+}
+
+type conditionTerm struct {
+	Key     string
+	Values  []string
+	Negated bool
+}
+
+// Condition is a list of terms that all have to match, e.g.
+// `distro=arch,arch=x86_64`, a term can match one of many values
+// (`distro=debian|fedora`) or be negated (`os!=darwin`)
+type Condition []conditionTerm
+
+var conditionKeys = []string{"os", "distro", "arch", "hostname"}
+
+func ParseCondition(txt string) (Condition, error) {
+	cond := Condition{}
+	for termTxt := range strings.SplitSeq(txt, ",") {
+		term := conditionTerm{}
+		key, value, found := strings.Cut(termTxt, "!=")
+		if found {
+			term.Negated = true
+		} else {
+			key, value, found = strings.Cut(termTxt, "=")
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !found || value == "" {
+			return nil, fmt.Errorf("condition must be in the form of key=value, instead got: '%s'", termTxt)
+		}
+		if !slices.Contains(conditionKeys, key) {
+			return nil, fmt.Errorf("unknown condition key '%s', expected one of %v", key, conditionKeys)
+		}
+
+		term.Key = key
+		term.Values = strings.Split(value, "|")
+		cond = append(cond, term)
+	}
+	return cond, nil
+}
+
+func (t conditionTerm) matches(f Facts) bool {
+	actual := []string{}
+	switch t.Key {
+	case "os":
+		actual = append(actual, f.OS)
+	case "distro":
+		actual = append(actual, f.Distro)
+		actual = append(actual, f.DistroLike...)
+	case "arch":
+		actual = append(actual, f.Arch)
+	case "hostname":
+		actual = append(actual, f.Hostname)
+	}
+
+	found := false
+	for _, value := range t.Values {
+		if slices.Contains(actual, value) {
+			found = true
+			break
+		}
+	}
+	return found != t.Negated
+}
+
+func (c Condition) MatchesFacts(f Facts) bool {
+	for _, term := range c {
+		if !term.matches(f) {
+			return false
+		}
+	}
+	return true
+}
+
+// Matches evaluates the condition against the facts gathered in Init
+func (c Condition) Matches() bool {
+	return c.MatchesFacts(facts)
+}
@@ -0,0 +1,42 @@
+package instructions
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var ubuntuFacts = Facts{
+	OS:         "linux",
+	Distro:     "ubuntu",
+	DistroLike: []string{"debian"},
+	Arch:       "x86_64",
+	Hostname:   "work-laptop",
+}
+
+func TestConditionMatchesFacts(t *testing.T) {
+	cases := map[string]bool{
+		"os=linux":                  true,
+		"os=darwin":                 false,
+		"distro=debian":             true,
+		"distro=arch|fedora":        false,
+		"distro=arch,arch=x86_64":   false,
+		"distro=ubuntu,arch=x86_64": true,
+		"os!=darwin":                true,
+		"hostname!=work-laptop":     false,
+	}
+
+	for txt, expected := range cases {
+		cond, err := ParseCondition(txt)
+		assert.NoError(t, err, txt)
+		assert.Equal(t, expected, cond.MatchesFacts(ubuntuFacts), txt)
+	}
+}
+
+func TestParseConditionErrors(t *testing.T) {
+	_, err := ParseCondition("kernel=linux")
+	assert.ErrorContains(t, err, "unknown condition key")
+
+	_, err = ParseCondition("os")
+	assert.Error(t, err)
+}
@@ -5,16 +5,20 @@ import (
 	"fmt"
 	"log/slog"
 	"os/exec"
+	"strconv"
+	"strings"
 )
 
 var Logger *slog.Logger = nil
 
 var systemPkgManager = INVALID
 
-func Init(l *slog.Logger) {
+func Init(l *slog.Logger, srcDir string) error {
 	Logger = l
+	GatherFacts()
 	FindSystemPkgManager()
 	FindAurPkgManager()
+	return errors.Join(LoadCustomMethods(srcDir), LoadPkgAliases(srcDir))
 }
 
 func FindSystemPkgManager() {
@@ -35,8 +39,20 @@ func FindSystemPkgManager() {
 	systemPkgManager = pkgManager
 }
 
+// SystemPkgManager is the package manager used for `system:` instructions,
+// INVALID if none was found
+func SystemPkgManager() InstallMethod {
+	return systemPkgManager
+}
+
 var aurPkgManager = INVALID
 
+// AurPkgManager is the AUR helper used for `aur:` instructions, INVALID if
+// none was found
+func AurPkgManager() InstallMethod {
+	return aurPkgManager
+}
+
 func FindAurPkgManager() {
 	pkgManager := INVALID
 
@@ -71,6 +87,73 @@ func cmdAvailable(cmd InstallMethod) bool {
 	return true
 }
 
+// IsAvailable reports whether the command behind the method exists on this
+// machine, `system` and `aur` are available if a package manager was found
+func (m *InstallMethod) IsAvailable() bool {
+	switch *m {
+	case System:
+		return systemPkgManager != INVALID
+	case Aur:
+		return aurPkgManager != INVALID
+	case Release:
+		// NOTE: handled by hm itself
+		return true
+	default:
+		if custom, ok := findCustomMethod(*m); ok {
+			return custom.Available
+		}
+		return cmdAvailable(*m)
+	}
+}
+
+// NeedsRoot reports whether the commands of the method are run through the
+// escalation tool (see SetEscalation), user-level methods (e.g. cargo) never
+// are
+func (m *InstallMethod) NeedsRoot() bool {
+	switch *m {
+	case System:
+		return systemPkgManager.NeedsRoot()
+	case Apt, Pacman, Dnf, Snap:
+		return true
+	default:
+		if custom, ok := findCustomMethod(*m); ok {
+			return custom.Root
+		}
+		return false
+	}
+}
+
+// LockGroup names the lock (usually a package database) taken by commands of
+// the method, commands of the same group can't run at the same time, empty
+// means that the commands don't lock anything
+func (m *InstallMethod) LockGroup() string {
+	switch *m {
+	case System:
+		return systemPkgManager.LockGroup()
+	// NOTE: AUR helpers install the built packages with pacman
+	case Aur, Yay, Paru, Pacaur, Aurman:
+		return string(Pacman)
+	case Cargo, CargoBinstall:
+		return string(Cargo)
+	case Bash, Release, INVALID:
+		return ""
+	default:
+		return string(*m)
+	}
+}
+
+// runs the command (split on spaces, without a shell) and reports whether it
+// exited successfully
+func execSucceeds(cmd string) bool {
+	splitCmd := strings.Fields(cmd)
+	if len(splitCmd) == 0 {
+		return false
+	}
+	err := exec.Command(splitCmd[0], splitCmd[1:]...).Run()
+	Logger.Debug("ran cmd", "cmd", cmd, "err", err)
+	return err == nil
+}
+
 type InstallMethod string
 
 const (
@@ -88,6 +171,14 @@ const (
 
 	Cargo         InstallMethod = "cargo"
 	CargoBinstall InstallMethod = "cargo-binstall"
+	Pip           InstallMethod = "pip"
+
+	Flatpak InstallMethod = "flatpak"
+	Snap    InstallMethod = "snap"
+
+	// downloads a release archive and extracts a single binary from it, this
+	// is handled by the lib itself instead of shelling out to a command
+	Release InstallMethod = "release"
 
 	Bash InstallMethod = "bash"
 
@@ -105,17 +196,99 @@ func IsValidInstallationMethod(method string) bool {
 		return true
 	case string(Aur), string(Yay), string(Paru), string(Pacaur), string(Aurman):
 		return true
-	case string(Cargo), string(CargoBinstall), string(Bash):
+	case string(Cargo), string(CargoBinstall), string(Pip), string(Bash):
+		return true
+	case string(Flatpak), string(Snap):
+		return true
+	case string(Release):
 		return true
 
 	case string(INVALID):
 		return false
 	default:
-		return false
+		_, ok := findCustomMethod(InstallMethod(method))
+		return ok
 	}
 }
 
-func (m *InstallMethod) CreateInstallCmd(pkg string) (cmd string, err error) {
+// Options are the per-instruction settings passed in parentheses right after
+// the method name, e.g. `flatpak(scope=user,remote=flathub):org.gimp.GIMP`
+type Options map[string]string
+
+const (
+	ScopeUser   = "user"
+	ScopeSystem = "system"
+)
+
+var (
+	invalidScopeErr      = errors.New("scope must be either 'user' or 'system'")
+	notCmdBasedMethodErr = errors.New("this installation method doesn't use commands, it has to be handled separately")
+)
+
+// Scope returns the effective installation scope for the method, empty string
+// means that the method doesn't have a notion of a scope
+func (m *InstallMethod) Scope(opts Options) (string, error) {
+	scope := opts["scope"]
+
+	switch *m {
+
+	case Flatpak:
+		if scope == "" {
+			scope = ScopeSystem
+		}
+	case Snap:
+		if scope == "" {
+			scope = ScopeSystem
+		}
+		if scope == ScopeUser {
+			return "", errors.New("snap doesn't support installing packages in the user scope")
+		}
+
+	default:
+		return "", nil
+	}
+
+	if scope != ScopeUser && scope != ScopeSystem {
+		return "", invalidScopeErr
+	}
+	return scope, nil
+}
+
+// RetriesOpt overrides the number of retries of the method for a single
+// instruction, e.g. `cargo(retries=5):ripgrep`
+const RetriesOpt = "retries"
+
+// DEFAULT_RETRIES is used by the methods which download (and often build)
+// packages, so they tend to fail on a flaky network
+const DEFAULT_RETRIES = 2
+
+// Retries returns how many times a failed installation or upgrade is retried
+func (m *InstallMethod) Retries(opts Options) (int, error) {
+	if txt, ok := opts[RetriesOpt]; ok {
+		n, err := strconv.Atoi(txt)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("retries must be a non-negative number, got '%s'", txt)
+		}
+		return n, nil
+	}
+
+	switch *m {
+	case Cargo, CargoBinstall, Pip, Release, Aur, Yay, Paru, Pacaur, Aurman:
+		return DEFAULT_RETRIES, nil
+	default:
+		if custom, ok := findCustomMethod(*m); ok {
+			return custom.Retries, nil
+		}
+		return 0, nil
+	}
+}
+
+func (m *InstallMethod) CreateInstallCmd(pkg string, opts Options) (cmd string, err error) {
+	cmd, err = m.createInstallCmd(pkg, opts)
+	return m.escalate(cmd), err
+}
+
+func (m *InstallMethod) createInstallCmd(pkg string, opts Options) (cmd string, err error) {
 	cmd, err = "", nil
 
 	switch *m {
@@ -144,23 +317,42 @@ func (m *InstallMethod) CreateInstallCmd(pkg string) (cmd string, err error) {
 	case Aurman:
 		cmd = installWithAurmanCmd(pkg)
 
+	// sandboxed apps
+	case Flatpak:
+		cmd, err = installWithFlatpakCmd(pkg, opts)
+	case Snap:
+		cmd, err = installWithSnapCmd(pkg, opts)
+
 	// misc
 	case Cargo:
 		cmd = installWithCargoCmd(pkg)
 	case CargoBinstall:
 		cmd = installWithCargoBinstallCmd(pkg)
+	case Pip:
+		cmd = installWithPipCmd(pkg)
 	case Bash:
 		// in this case the package is actually a command passed in by the user
 		cmd = pkg
+	case Release:
+		err = notCmdBasedMethodErr
 
 	default:
-		err = errors.New(fmt.Sprintf("this installation method is either not implemented, or is invalid, method='%s'", *m))
+		if custom, ok := findCustomMethod(*m); ok {
+			cmd, err = custom.installCmd(pkg, opts)
+			break
+		}
+		err = UnknownMethodError{Method: string(*m)}
 	}
 
 	return cmd, err
 }
 
-func (m *InstallMethod) CreateUninstallCmd(pkg string) (cmd string, err error) {
+func (m *InstallMethod) CreateUninstallCmd(pkg string, opts Options) (cmd string, err error) {
+	cmd, err = m.createUninstallCmd(pkg, opts)
+	return m.escalate(cmd), err
+}
+
+func (m *InstallMethod) createUninstallCmd(pkg string, opts Options) (cmd string, err error) {
 	cmd, err = "", nil
 
 	switch *m {
@@ -189,14 +381,103 @@ func (m *InstallMethod) CreateUninstallCmd(pkg string) (cmd string, err error) {
 	case Aurman:
 		cmd = uninstallWithAurmanCmd(pkg)
 
+	// sandboxed apps
+	case Flatpak:
+		cmd, err = uninstallWithFlatpakCmd(pkg, opts)
+	case Snap:
+		cmd, err = uninstallWithSnapCmd(pkg, opts)
+
 	// misc
 	case Cargo:
 		cmd = uninstallWithCargoCmd(pkg)
 	case CargoBinstall:
 		cmd = uninstallWithCargoBinstallCmd(pkg)
+	case Pip:
+		cmd = uninstallWithPipCmd(pkg)
+	case Release:
+		err = notCmdBasedMethodErr
 
 	default:
-		err = errors.New(fmt.Sprintf("this uninstallation method is either not implemented, or is invalid, method='%s'", *m))
+		if custom, ok := findCustomMethod(*m); ok {
+			cmd, err = custom.uninstallCmd(pkg, opts)
+			break
+		}
+		err = UnknownMethodError{Method: string(*m)}
+	}
+
+	return cmd, err
+}
+
+func (m *InstallMethod) CreateUpgradeCmd(pkg string, opts Options) (cmd string, err error) {
+	cmd, err = m.createUpgradeCmd(pkg, opts)
+	return m.escalate(cmd), err
+}
+
+func (m *InstallMethod) createUpgradeCmd(pkg string, opts Options) (cmd string, err error) {
+	cmd, err = "", nil
+
+	switch *m {
+
+	case Pip:
+		cmd = upgradeWithPipCmd(pkg)
+
+	// sandboxed apps
+	case Flatpak:
+		cmd, err = upgradeWithFlatpakCmd(pkg, opts)
+	case Snap:
+		cmd, err = upgradeWithSnapCmd(pkg, opts)
+
+	// NOTE: for everything else rerunning the install instruction is enough to
+	// get the newest version
+	default:
+		if custom, ok := findCustomMethod(*m); ok {
+			cmd, err = custom.upgradeCmd(pkg, opts)
+			break
+		}
+		cmd, err = m.createInstallCmd(pkg, opts)
+	}
+
+	return cmd, err
+}
+
+// CreateCheckCmd creates a command that exits with 0 only if the package is
+// installed
+func (m *InstallMethod) CreateCheckCmd(pkg string, opts Options) (cmd string, err error) {
+	cmd, err = "", nil
+
+	switch *m {
+
+	// system commands
+	case System:
+		cmd, err = checkWithSystemCmd(pkg)
+	case Apt:
+		cmd = checkWithAptCmd(pkg)
+	case Dnf:
+		cmd = checkWithDnfCmd(pkg)
+	case Brew:
+		cmd = checkWithBrewCmd(pkg)
+	case Pacman:
+		cmd = checkWithPacmanCmd(pkg)
+
+	// aur packages are registered in the pacman database
+	case Aur, Yay, Paru, Pacaur, Aurman:
+		cmd = checkWithPacmanCmd(pkg)
+
+	case Pip:
+		cmd = checkWithPipCmd(pkg)
+
+	// sandboxed apps
+	case Flatpak:
+		cmd, err = checkWithFlatpakCmd(pkg, opts)
+	case Snap:
+		cmd, err = checkWithSnapCmd(pkg, opts)
+
+	default:
+		if custom, ok := findCustomMethod(*m); ok {
+			cmd, err = custom.checkCmd(pkg, opts)
+			break
+		}
+		err = errors.New(fmt.Sprintf("checking if a package is installed is not supported for this method, method='%s'", *m))
 	}
 
 	return cmd, err
@@ -218,28 +499,153 @@ func uninstallWithCargoBinstallCmd(pkg string) string {
 	return uninstallWithCargoCmd(pkg)
 }
 
+func installWithPipCmd(pkg string) string {
+	return "pip install --user " + pkg
+}
+
+func uninstallWithPipCmd(pkg string) string {
+	return "pip uninstall -y " + pkg
+}
+
+func upgradeWithPipCmd(pkg string) string {
+	return "pip install --user --upgrade " + pkg
+}
+
+func checkWithPipCmd(pkg string) string {
+	return "pip show " + pkg
+}
+
+func flatpakArgs(opts Options) (string, error) {
+	method := Flatpak
+	scope, err := method.Scope(opts)
+	if err != nil {
+		return "", err
+	}
+	return "--" + scope + " -y --noninteractive ", nil
+}
+
+func installWithFlatpakCmd(pkg string, opts Options) (string, error) {
+	args, err := flatpakArgs(opts)
+	if err != nil {
+		return "", err
+	}
+	if remote := opts["remote"]; remote != "" {
+		args += remote + " "
+	}
+	return "flatpak install " + args + pkg, nil
+}
+
+func uninstallWithFlatpakCmd(pkg string, opts Options) (string, error) {
+	args, err := flatpakArgs(opts)
+	if err != nil {
+		return "", err
+	}
+	return "flatpak uninstall " + args + pkg, nil
+}
+
+func upgradeWithFlatpakCmd(pkg string, opts Options) (string, error) {
+	args, err := flatpakArgs(opts)
+	if err != nil {
+		return "", err
+	}
+	return "flatpak update " + args + pkg, nil
+}
+
+func checkWithFlatpakCmd(pkg string, opts Options) (string, error) {
+	method := Flatpak
+	scope, err := method.Scope(opts)
+	if err != nil {
+		return "", err
+	}
+	return "flatpak info --" + scope + " " + pkg, nil
+}
+
+// snap only has a system scope, the remote option is used as the channel to
+// install from, because snaps don't have multiple remotes
+func snapArgs(opts Options) (string, error) {
+	method := Snap
+	_, err := method.Scope(opts)
+	if err != nil {
+		return "", err
+	}
+	args := ""
+	if channel := opts["remote"]; channel != "" {
+		args += "--channel=" + channel + " "
+	}
+	if opts["classic"] == "true" {
+		args += "--classic "
+	}
+	return args, nil
+}
+
+func installWithSnapCmd(pkg string, opts Options) (string, error) {
+	args, err := snapArgs(opts)
+	if err != nil {
+		return "", err
+	}
+	return "snap install " + args + pkg, nil
+}
+
+func uninstallWithSnapCmd(pkg string, opts Options) (string, error) {
+	method := Snap
+	_, err := method.Scope(opts)
+	if err != nil {
+		return "", err
+	}
+	return "snap remove " + pkg, nil
+}
+
+func upgradeWithSnapCmd(pkg string, opts Options) (string, error) {
+	args, err := snapArgs(opts)
+	if err != nil {
+		return "", err
+	}
+	return "snap refresh " + args + pkg, nil
+}
+
+func checkWithSnapCmd(pkg string, opts Options) (string, error) {
+	method := Snap
+	_, err := method.Scope(opts)
+	if err != nil {
+		return "", err
+	}
+	return "snap list " + pkg, nil
+}
+
 func installWithPacmanCmd(pkg string) string {
-	return "sudo pacman -S --noconfirm " + pkg
+	return "pacman -S --noconfirm " + pkg
 }
 
 func uninstallWithPacmanCmd(pkg string) string {
-	return "sudo pacman -R --noconfirm " + pkg
+	return "pacman -R --noconfirm " + pkg
+}
+
+func checkWithPacmanCmd(pkg string) string {
+	return "pacman -Q " + pkg
 }
 
 func installWithAptCmd(pkg string) string {
-	return "sudo apt install -y " + pkg
+	return "apt install -y " + pkg
 }
 
 func uninstallWithAptCmd(pkg string) string {
-	return "sudo apt remove -y " + pkg
+	return "apt remove -y " + pkg
+}
+
+func checkWithAptCmd(pkg string) string {
+	return "dpkg -s " + pkg
 }
 
 func installWithDnfCmd(pkg string) string {
-	return "dnf install " + pkg
+	return "dnf install -y " + pkg
 }
 
 func uninstallWithDnfCmd(pkg string) string {
-	return "dnf remove " + pkg
+	return "dnf remove -y " + pkg
+}
+
+func checkWithDnfCmd(pkg string) string {
+	return "rpm -q " + pkg
 }
 
 func installWithBrewCmd(pkg string) string {
@@ -250,6 +656,10 @@ func uninstallWithBrewCmd(pkg string) string {
 	return "brew uninstall " + pkg
 }
 
+func checkWithBrewCmd(pkg string) string {
+	return "brew list " + pkg
+}
+
 func installWithAurCmd(pkg string) (string, error) {
 	return genAurInstallCmd(aurPkgManager, pkg)
 }
@@ -259,43 +669,35 @@ func uninstallWithAurCmd(pkg string) (string, error) {
 }
 
 func installWithYayCmd(pkg string) string {
-	return "yay -S --sudoloop " + pkg
+	return "yay -S " + aurEscalationArgs() + pkg
 }
 
 func uninstallWithYayCmd(pkg string) string {
-	return "yay -R " + pkg
+	return "yay -R " + aurEscalationArgs() + pkg
 }
 
 func installWithParuCmd(pkg string) string {
-	return "paru -S --sudoloop " + pkg
+	return "paru -S " + aurEscalationArgs() + pkg
 }
 
 func uninstallWithParuCmd(pkg string) string {
-	return "paru -R " + pkg
+	return "paru -R " + aurEscalationArgs() + pkg
 }
 
 func installWithPacaurCmd(pkg string) string {
-	// TODO: verify
-	panic("verify")
-	return "pacaur -S " + pkg
+	return "pacaur -S " + aurEscalationArgs() + pkg
 }
 
 func uninstallWithPacaurCmd(pkg string) string {
-	// TODO: verify
-	panic("verify")
-	return "pacaur -R " + pkg
+	return "pacaur -R " + aurEscalationArgs() + pkg
 }
 
 func installWithAurmanCmd(pkg string) string {
-	// TODO: verify
-	panic("verify")
-	return "aurman -S " + pkg
+	return "aurman -S " + aurEscalationArgs() + pkg
 }
 
 func uninstallWithAurmanCmd(pkg string) string {
-	// TODO: verify
-	panic("verify")
-	return "aurman -R " + pkg
+	return "aurman -R " + aurEscalationArgs() + pkg
 }
 
 func installWithSystemCmd(pkg string) (string, error) {
@@ -306,6 +708,10 @@ func uninstallWithSystemCmd(pkg string) (string, error) {
 	return genSystemUninstallCmd(systemPkgManager, pkg)
 }
 
+func checkWithSystemCmd(pkg string) (string, error) {
+	return genSystemCheckCmd(systemPkgManager, pkg)
+}
+
 var (
 	couldntFindSysPkgManagerErr = errors.New("couldn't detect system package manager")
 	notSystemPkgManagerErr      = errors.New("passed in an installation method that is not a system one")
@@ -359,6 +765,30 @@ func genSystemUninstallCmd(manager InstallMethod, pkg string) (cmd string, err e
 	return cmd, nil
 }
 
+func genSystemCheckCmd(manager InstallMethod, pkg string) (cmd string, err error) {
+	cmd, err = "", nil
+
+	switch manager {
+
+	case INVALID:
+		err = couldntFindSysPkgManagerErr
+	default:
+		err = notSystemPkgManagerErr
+
+	case Pacman:
+		cmd = checkWithPacmanCmd(pkg)
+	case Apt:
+		cmd = checkWithAptCmd(pkg)
+	case Dnf:
+		cmd = checkWithDnfCmd(pkg)
+	case Brew:
+		cmd = checkWithBrewCmd(pkg)
+
+	}
+
+	return cmd, err
+}
+
 var (
 	couldntFindAurPkgManagerErr = errors.New("couldn't detect aur package manager")
 	notAurPkgManagerErr         = errors.New("passed in an installation method that is not an aur one")
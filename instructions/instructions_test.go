@@ -0,0 +1,46 @@
+package instructions
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockGroup(t *testing.T) {
+	prev := systemPkgManager
+	defer func() { systemPkgManager = prev }()
+	systemPkgManager = Pacman
+
+	for method, group := range map[InstallMethod]string{
+		Pacman:        "pacman",
+		System:        "pacman",
+		Yay:           "pacman",
+		Paru:          "pacman",
+		Apt:           "apt",
+		Cargo:         "cargo",
+		CargoBinstall: "cargo",
+		Bash:          "",
+		Release:       "",
+	} {
+		assert.Equal(t, group, method.LockGroup(), method)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	cargo, apt := Cargo, Apt
+
+	retries, err := cargo.Retries(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, DEFAULT_RETRIES, retries)
+
+	retries, err = apt.Retries(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, retries)
+
+	retries, err = apt.Retries(Options{RetriesOpt: "3"})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, retries)
+
+	_, err = cargo.Retries(Options{RetriesOpt: "-1"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,75 @@
+package instructions
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// FindBinaryOwner looks for an executable with the given name in $PATH and
+// asks the package managers which package it came from, found is false when
+// the binary doesn't exist or no known package manager owns it
+func FindBinaryOwner(binary string) (method InstallMethod, pkg string, found bool) {
+	path, err := exec.LookPath(binary)
+	if err != nil {
+		Logger.Debug("binary not found in $PATH", "binary", binary, "err", err)
+		return INVALID, "", false
+	}
+	// NOTE: package managers (and cargo) know about the real file, not the
+	// symlinks pointing to it
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	Logger.Debug("looking for the owner of a binary", "binary", binary, "path", path)
+
+	home, _ := os.UserHomeDir()
+	if home != "" && filepath.Dir(path) == filepath.Join(home, ".cargo", "bin") {
+		return Cargo, binary, true
+	}
+
+	pkg, found = systemPkgOwning(systemPkgManager, path)
+	if !found {
+		return INVALID, "", false
+	}
+	return systemPkgManager, pkg, true
+}
+
+func systemPkgOwning(manager InstallMethod, path string) (string, bool) {
+	switch manager {
+	case Pacman:
+		out, err := exec.Command("pacman", "-Qoq", path).Output()
+		if err != nil {
+			return "", false
+		}
+		return strings.TrimSpace(string(out)), true
+	case Apt:
+		// prints `pkg: /path/to/file` or `pkg:arch: /path/to/file`
+		out, err := exec.Command("dpkg", "-S", path).Output()
+		if err != nil {
+			return "", false
+		}
+		pkg, _, _ := strings.Cut(strings.TrimSpace(string(out)), ":")
+		return pkg, pkg != ""
+	case Dnf:
+		out, err := exec.Command("rpm", "-qf", "--queryformat", "%{NAME}", path).Output()
+		if err != nil {
+			return "", false
+		}
+		return strings.TrimSpace(string(out)), true
+	case Brew:
+		return brewPkgFromPath(path)
+	default:
+		return "", false
+	}
+}
+
+// brew installs everything into `<prefix>/Cellar/<pkg>/<version>/...`
+func brewPkgFromPath(path string) (string, bool) {
+	_, rest, found := strings.Cut(path, "/Cellar/")
+	if !found {
+		return "", false
+	}
+	pkg, _, _ := strings.Cut(rest, "/")
+	return pkg, pkg != ""
+}
@@ -0,0 +1,16 @@
+package instructions
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBrewPkgFromPath(t *testing.T) {
+	pkg, found := brewPkgFromPath("/opt/homebrew/Cellar/neovim/0.10.2/bin/nvim")
+	assert.True(t, found)
+	assert.Equal(t, "neovim", pkg)
+
+	_, found = brewPkgFromPath("/usr/bin/nvim")
+	assert.False(t, found)
+}
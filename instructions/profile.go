@@ -0,0 +1,113 @@
+package instructions
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// keys of the profile, the facts usable in instruction conditions and the
+// package managers
+var profileKeys = append(slices.Clone(conditionKeys), "system_pkg_manager", "aur_pkg_manager")
+
+// LoadProfile overrides the facts and package managers detected in Init with
+// the ones from the profile of this machine (written by `hm init`), each line
+// looks like this:
+//
+//	distro = "arch"
+//
+// keys that are left out or empty are detected as usual
+func LoadProfile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		// NOTE: file not existing is not an error in this case (everything
+		// is detected)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	profile, err := parseProfile(file)
+	if err != nil {
+		return WithPath(err, path)
+	}
+
+	for key, value := range profile {
+		switch key {
+		case "os":
+			facts.OS = value
+		case "distro":
+			// NOTE: the detected distro could be based on other distros
+			// than the one from the profile
+			facts.Distro, facts.DistroLike = value, []string{}
+		case "arch":
+			facts.Arch = value
+		case "hostname":
+			facts.Hostname = value
+		case "system_pkg_manager":
+			systemPkgManager = InstallMethod(value)
+		case "aur_pkg_manager":
+			aurPkgManager = InstallMethod(value)
+		}
+	}
+	Logger.Info("Loaded the profile of the machine", "path", path, "facts", facts, "systemPkgManager", systemPkgManager, "aurPkgManager", aurPkgManager)
+	return nil
+}
+
+func parseProfile(r io.Reader) (map[string]string, error) {
+	profile := map[string]string{}
+
+	scanner := bufio.NewScanner(r)
+	lineNr := 0
+	for scanner.Scan() {
+		lineNr++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			return nil, ParseError{Line: lineNr, Text: line, Err: errors.New("expected key = value")}
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !slices.Contains(profileKeys, key) {
+			return nil, ParseError{Line: lineNr, Text: line, Err: fmt.Errorf("unknown key '%s', expected one of %v", key, profileKeys)}
+		}
+		if strings.HasPrefix(value, `"`) {
+			unquoted, err := strconv.Unquote(value)
+			if err != nil {
+				return nil, ParseError{Line: lineNr, Text: line, Err: errors.New("invalid quoted value")}
+			}
+			value = unquoted
+		}
+		if value == "" {
+			continue
+		}
+
+		switch key {
+		case "system_pkg_manager":
+			switch InstallMethod(value) {
+			case Apt, Pacman, Dnf, Brew:
+			default:
+				return nil, ParseError{Line: lineNr, Text: line, Err: fmt.Errorf("'%s' is not a system package manager", value)}
+			}
+		case "aur_pkg_manager":
+			switch InstallMethod(value) {
+			case Yay, Paru, Pacaur, Aurman:
+			default:
+				return nil, ParseError{Line: lineNr, Text: line, Err: fmt.Errorf("'%s' is not an aur package manager", value)}
+			}
+		}
+		profile[key] = value
+	}
+
+	return profile, scanner.Err()
+}
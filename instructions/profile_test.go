@@ -0,0 +1,40 @@
+package instructions
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseProfile(t *testing.T) {
+	txt := "# this machine\ndistro = \"arch\"\nhostname = work\narch = \"\"\nsystem_pkg_manager = \"pacman\"\n"
+	profile, err := parseProfile(strings.NewReader(txt))
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"distro": "arch", "hostname": "work", "system_pkg_manager": "pacman"}, profile)
+
+	_, err = parseProfile(strings.NewReader("system_pkg_manager = \"cargo\"\n"))
+	assert.ErrorContains(t, err, "not a system package manager")
+	_, err = parseProfile(strings.NewReader("\nkernel = \"linux\"\n"))
+	assert.ErrorContains(t, err, "<input>:2: unknown key 'kernel'")
+}
+
+func TestLoadProfileOverridesDetectedFacts(t *testing.T) {
+	Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	prevFacts, prevManager := facts, systemPkgManager
+	t.Cleanup(func() { facts, systemPkgManager = prevFacts, prevManager })
+	facts = Facts{OS: "linux", Distro: "ubuntu", DistroLike: []string{"debian"}, Hostname: "laptop"}
+	systemPkgManager = Apt
+	path := t.TempDir() + "/profile.toml"
+	assert.NoError(t, os.WriteFile(path, []byte("distro = \"fedora\"\nsystem_pkg_manager = \"dnf\"\n"), 0o644))
+
+	assert.NoError(t, LoadProfile(path))
+
+	assert.Equal(t, Facts{OS: "linux", Distro: "fedora", DistroLike: []string{}, Hostname: "laptop"}, facts)
+	assert.Equal(t, Dnf, systemPkgManager)
+	assert.NoError(t, LoadProfile(t.TempDir()+"/missing.toml"), "the profile is optional")
+}
@@ -0,0 +1,330 @@
+package instructions
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Constraint is a version requirement written right after the package name,
+// e.g. `cargo:ripgrep==14.1.0` or `system:neovim>=0.10`
+type Constraint struct {
+	Op      string `json:"op"`
+	Version string `json:"version"`
+}
+
+// longer operators first, so that `>=` is not parsed as `>`
+var constraintOps = []string{"==", ">=", "<=", ">", "<"}
+
+func (c *Constraint) IsEmpty() bool {
+	return c == nil || c.Version == ""
+}
+
+func (c Constraint) String() string {
+	return c.Op + c.Version
+}
+
+// SplitConstraint splits `pkg>=1.0` into `pkg` and its constraint, the
+// constraint is nil if the package is not pinned
+func SplitConstraint(pkg string) (string, *Constraint, error) {
+	idx := strings.IndexAny(pkg, "=<>")
+	if idx == -1 {
+		return pkg, nil, nil
+	}
+
+	name, rest := pkg[:idx], pkg[idx:]
+	for _, op := range constraintOps {
+		if !strings.HasPrefix(rest, op) {
+			continue
+		}
+		version := rest[len(op):]
+		if name == "" || version == "" {
+			break
+		}
+		return name, &Constraint{Op: op, Version: version}, nil
+	}
+
+	return pkg, nil, fmt.Errorf("invalid version constraint in '%s', expected one of %v followed by a version", pkg, constraintOps)
+}
+
+// Satisfied reports whether the version matches the constraint, empty
+// constraint is satisfied by every version
+func (c *Constraint) Satisfied(version string) bool {
+	if c.IsEmpty() {
+		return true
+	}
+
+	cmp := CompareVersions(version, c.Version)
+	switch c.Op {
+	case "==":
+		// `==0.10` should be satisfied by `0.10.2`, same as most package
+		// managers treat partial versions
+		return cmp == 0 || versionHasPrefix(version, c.Version)
+	case ">=":
+		return cmp >= 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case "<":
+		return cmp < 0
+	default:
+		return false
+	}
+}
+
+// splits the epoch (`1:` in `1:2.3.4-1`, used by Debian and Arch packages) off
+// the version, versions without one have the epoch 0
+func splitEpoch(version string) (int, string) {
+	prefix, rest, found := strings.Cut(version, ":")
+	if !found {
+		return 0, version
+	}
+	epoch, err := strconv.Atoi(prefix)
+	if err != nil {
+		return 0, version
+	}
+	return epoch, rest
+}
+
+// drops the package release (pacman's pkgrel or the Debian revision, `-1` in
+// `1.2.3-1`), both are reported by the package managers, but pins are written
+// against the upstream version
+func upstreamVersion(version string) string {
+	if idx := strings.LastIndex(version, "-"); idx != -1 {
+		return version[:idx]
+	}
+	return version
+}
+
+// numeric parts of the upstream version, without the epoch and the release
+func versionParts(version string) []int {
+	_, version = splitEpoch(version)
+	version = upstreamVersion(version)
+	parts := []int{}
+	fields := strings.FieldsFunc(version, func(r rune) bool { return !unicode.IsDigit(r) })
+	for _, field := range fields {
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			break
+		}
+		parts = append(parts, n)
+	}
+	return parts
+}
+
+// CompareVersions compares epochs first and then only the numeric parts of the
+// versions, so `1.2.3-1`, `v1.2.3` and `1.2.3` are all equal, but `1:1.0` is
+// newer than `2.0`
+func CompareVersions(a, b string) int {
+	epochA, _ := splitEpoch(a)
+	epochB, _ := splitEpoch(b)
+	if epochA != epochB {
+		if epochA < epochB {
+			return -1
+		}
+		return 1
+	}
+
+	partsA, partsB := versionParts(a), versionParts(b)
+	for idx := range max(len(partsA), len(partsB)) {
+		x, y := 0, 0
+		if idx < len(partsA) {
+			x = partsA[idx]
+		}
+		if idx < len(partsB) {
+			y = partsB[idx]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// a prefix without an epoch matches versions with any epoch, so that `==2.3`
+// is satisfied by `1:2.3.4-1`
+func versionHasPrefix(version, prefix string) bool {
+	if !strings.Contains(prefix, ":") {
+		_, version = splitEpoch(version)
+	}
+	epochV, _ := splitEpoch(version)
+	epochP, _ := splitEpoch(prefix)
+	if epochV != epochP {
+		return false
+	}
+	partsV, partsP := versionParts(version), versionParts(prefix)
+	if len(partsP) > len(partsV) {
+		return false
+	}
+	for idx := range partsP {
+		if partsV[idx] != partsP[idx] {
+			return false
+		}
+	}
+	return true
+}
+
+var exactPinOnlyErr = errors.New("this installation method only supports exact (==) version pins")
+
+// PinPkg creates the package argument for the install command that makes the
+// package manager install the version matching the constraint. Methods that
+// can't express a range constraint install the newest version, `hm status`
+// reports it if that doesn't satisfy the constraint.
+func (m *InstallMethod) PinPkg(pkg string, c *Constraint) (string, error) {
+	if c.IsEmpty() {
+		return pkg, nil
+	}
+
+	manager := *m
+	switch manager {
+	case System:
+		manager = systemPkgManager
+	case Aur:
+		manager = aurPkgManager
+	}
+
+	exact := c.Op == "=="
+	switch manager {
+
+	case Cargo, CargoBinstall:
+		if exact {
+			return pkg + " --version " + c.Version, nil
+		}
+		return pkg + " --version " + c.String(), nil
+	case Pip:
+		return pkg + c.String(), nil
+	case Apt:
+		if exact {
+			return pkg + "=" + c.Version, nil
+		}
+	case Dnf:
+		if exact {
+			return pkg + "-" + c.Version, nil
+		}
+	case Brew:
+		if exact {
+			return pkg + "@" + c.Version, nil
+		}
+	case Snap:
+		// NOTE: snaps are versioned by channels, e.g. `snap(remote=1.2/stable):pkg`
+		return "", errors.New("snap doesn't support version pins, use the remote option to pick a channel")
+	case Release:
+		// the version is applied by expanding the url
+		if exact {
+			return pkg, nil
+		}
+		return "", exactPinOnlyErr
+
+	case Pacman, Yay, Paru, Pacaur, Aurman, Flatpak:
+		if exact {
+			return "", fmt.Errorf("%s can't install a specific version of a package", manager)
+		}
+	case Bash:
+		return "", errors.New("bash instructions can't be pinned")
+	default:
+		return "", fmt.Errorf("version pins are not supported for method '%s'", *m)
+	}
+
+	Logger.Info("package manager can't express the version constraint, installing the newest version", "method", *m, "pkg", pkg, "constraint", c.String())
+	return pkg, nil
+}
+
+// CreateVersionCmd creates a command which output contains the installed
+// version of the package, use ParseVersionOutput to extract it
+func (m *InstallMethod) CreateVersionCmd(pkg string, opts Options) (cmd string, err error) {
+	cmd, err = "", nil
+
+	manager := *m
+	switch manager {
+	case System:
+		manager = systemPkgManager
+	case Aur:
+		manager = aurPkgManager
+	}
+
+	switch manager {
+
+	case Pacman, Yay, Paru, Pacaur, Aurman:
+		cmd = "pacman -Q " + pkg
+	case Apt:
+		cmd = "dpkg-query -W -f=${Version} " + pkg
+	case Dnf:
+		cmd = "rpm -q --qf %{VERSION} " + pkg
+	case Brew:
+		cmd = "brew list --versions " + pkg
+	case Cargo, CargoBinstall:
+		cmd = "cargo install --list"
+	case Pip:
+		cmd = "pip show " + pkg
+	case Flatpak:
+		cmd, err = checkWithFlatpakCmd(pkg, opts)
+	case Snap:
+		cmd = "snap list " + pkg
+
+	default:
+		err = fmt.Errorf("reading the installed version is not supported for method '%s'", *m)
+	}
+
+	return cmd, err
+}
+
+func (m *InstallMethod) ParseVersionOutput(pkg, out string) (string, error) {
+	manager := *m
+	switch manager {
+	case System:
+		manager = systemPkgManager
+	case Aur:
+		manager = aurPkgManager
+	}
+
+	version := ""
+	switch manager {
+
+	case Pacman, Yay, Paru, Pacaur, Aurman, Brew:
+		// `pkg 1.2.3-1`
+		fields := strings.Fields(out)
+		if len(fields) >= 2 {
+			version = fields[len(fields)-1]
+		}
+	case Apt, Dnf:
+		version = strings.TrimSpace(out)
+	case Cargo, CargoBinstall:
+		// `ripgrep v14.1.0:`
+		for line := range strings.Lines(out) {
+			fields := strings.Fields(line)
+			if len(fields) == 2 && fields[0] == pkg {
+				version = strings.TrimSuffix(strings.TrimPrefix(fields[1], "v"), ":")
+				break
+			}
+		}
+	case Pip, Flatpak:
+		// `Version: 1.2.3`
+		for line := range strings.Lines(out) {
+			key, value, found := strings.Cut(line, ":")
+			if found && strings.TrimSpace(key) == "Version" {
+				version = strings.TrimSpace(value)
+				break
+			}
+		}
+	case Snap:
+		// table with a header, version is the second column
+		lines := strings.Split(strings.TrimSpace(out), "\n")
+		if len(lines) >= 2 {
+			fields := strings.Fields(lines[1])
+			if len(fields) >= 2 {
+				version = fields[1]
+			}
+		}
+	}
+
+	if version == "" {
+		return "", fmt.Errorf("couldn't find the version of '%s' in the output of the version command", pkg)
+	}
+	return version, nil
+}
@@ -0,0 +1,113 @@
+package instructions
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitConstraint(t *testing.T) {
+	pkg, c, err := SplitConstraint("neovim>=0.10")
+	assert.NoError(t, err)
+	assert.Equal(t, "neovim", pkg)
+	assert.Equal(t, &Constraint{Op: ">=", Version: "0.10"}, c)
+
+	pkg, c, err = SplitConstraint("python@3.12")
+	assert.NoError(t, err)
+	assert.Equal(t, "python@3.12", pkg)
+	assert.Nil(t, c)
+
+	_, _, err = SplitConstraint("ripgrep=14.1.0")
+	assert.Error(t, err)
+}
+
+func TestConstraintSatisfied(t *testing.T) {
+	atLeast := Constraint{Op: ">=", Version: "0.10"}
+	assert.True(t, atLeast.Satisfied("0.10.2"))
+	assert.True(t, atLeast.Satisfied("v0.11.0"))
+	assert.False(t, atLeast.Satisfied("0.9.5"))
+
+	exact := Constraint{Op: "==", Version: "14.1"}
+	assert.True(t, exact.Satisfied("14.1.0-1"))
+	assert.False(t, exact.Satisfied("14.2.0"))
+
+	var empty *Constraint
+	assert.True(t, empty.Satisfied("1.0"))
+}
+
+func TestConstraintsIgnorePackageReleases(t *testing.T) {
+	assert.Equal(t, 0, CompareVersions("1.2.3-1", "1.2.3"))
+	assert.Equal(t, 0, CompareVersions("2.34.1-1ubuntu1.9", "2.34.1"))
+
+	for _, tc := range []struct {
+		op        string
+		installed string
+		satisfied bool
+	}{
+		{"<=", "14.1.0-1", true},
+		{"<", "14.1.0-1", false},
+		{">", "14.1.0-1", false},
+		{">=", "14.1.0-1", true},
+		{"<", "14.0.9-3", true},
+		{">", "14.1.1-1", true},
+		{"<=", "1:14.0.0-1", false},
+	} {
+		c := Constraint{Op: tc.op, Version: "14.1.0"}
+		assert.Equal(t, tc.satisfied, c.Satisfied(tc.installed), "%s%s with %s installed", tc.op, c.Version, tc.installed)
+	}
+}
+
+func TestCompareVersionsWithEpochs(t *testing.T) {
+	assert.Equal(t, 1, CompareVersions("1:1.0-1", "2.0"))
+	assert.Equal(t, -1, CompareVersions("1:2.0", "2:1.0"))
+	assert.Equal(t, 0, CompareVersions("1:2.30", "1:2.30.0"))
+	assert.Equal(t, -1, CompareVersions("1:2.30.2", "1:2.31"))
+
+	atLeast := Constraint{Op: ">=", Version: "1:2.30"}
+	assert.True(t, atLeast.Satisfied("1:2.30.2-1"))
+	assert.False(t, atLeast.Satisfied("2.40"))
+
+	exact := Constraint{Op: "==", Version: "2.30"}
+	assert.True(t, exact.Satisfied("1:2.30.2-1"), "exact pins without an epoch ignore it")
+	exact = Constraint{Op: "==", Version: "2:2.30"}
+	assert.False(t, exact.Satisfied("1:2.30.2-1"))
+}
+
+func TestPinPkg(t *testing.T) {
+	exact := &Constraint{Op: "==", Version: "14.1.0"}
+
+	cargo := Cargo
+	pkg, err := cargo.PinPkg("ripgrep", exact)
+	assert.NoError(t, err)
+	assert.Equal(t, "ripgrep --version 14.1.0", pkg)
+
+	apt := Apt
+	pkg, err = apt.PinPkg("ripgrep", exact)
+	assert.NoError(t, err)
+	assert.Equal(t, "ripgrep=14.1.0", pkg)
+
+	pip := Pip
+	pkg, err = pip.PinPkg("black", &Constraint{Op: ">=", Version: "24.1"})
+	assert.NoError(t, err)
+	assert.Equal(t, "black>=24.1", pkg)
+
+	pacman := Pacman
+	_, err = pacman.PinPkg("ripgrep", exact)
+	assert.Error(t, err)
+}
+
+func TestParseVersionOutput(t *testing.T) {
+	cargo := Cargo
+	out := "bat v0.24.0:\n    bat\nripgrep v14.1.0:\n    rg\n"
+	version, err := cargo.ParseVersionOutput("ripgrep", out)
+	assert.NoError(t, err)
+	assert.Equal(t, "14.1.0", version)
+
+	pacman := Pacman
+	version, err = pacman.ParseVersionOutput("neovim", "neovim 0.10.2-1\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "0.10.2-1", version)
+
+	_, err = pacman.ParseVersionOutput("neovim", "")
+	assert.Error(t, err)
+}
@@ -2,35 +2,106 @@ package lib
 
 import (
 	"blanktiger/hm/configuration"
+	i "blanktiger/hm/instructions"
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
 )
 
-func Symlink(c *configuration.Configuration, configs []Config) error {
-	for _, cfg := range configs {
-		Logger.Info("symlinking", "from", cfg.From, "to", cfg.To)
-		err := symlink(cfg.From, cfg.To)
+// Deploy symlinks (or copies in copy mode) the configs of lockAfter, deploying
+// stops before the next config once ctx is done, every config is deployed
+// again on the next run anyway, deploy hooks only run for configs that changed
+// since lockBefore was saved
+func Deploy(ctx context.Context, c *configuration.Configuration, lockBefore, lockAfter *Lockfile, report *Report) error {
+	checksums := make([]string, len(lockAfter.Configs))
+	changes := make([]string, len(lockAfter.Configs))
+	changed := []string{}
+	for idx, cfg := range lockAfter.Configs {
+		checksum, err := sourceChecksum(cfg.From)
+		if err != nil {
+			Logger.Warn("couldn't compute the checksum of the config, treating it as changed", "cfgName", cfg.Name, "err", err)
+		}
+		checksums[idx] = checksum
+		changes[idx] = deployChange(lockBefore, lockAfter.Mode, cfg, checksum)
+		if changes[idx] != "" {
+			changed = append(changed, cfg.Name)
+		}
+	}
+
+	err := runGlobalHook(ctx, c.SourceCfgDir, HookPreDeploy, changed, report)
+	if err != nil {
+		return fmt.Errorf("global %s hook failed: %w", HookPreDeploy, err)
+	}
+
+	deployed := []string{}
+	for idx, cfg := range lockAfter.Configs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		ok, err := deployCfg(ctx, c, cfg, changes[idx], report)
 		if err != nil {
 			return err
 		}
+		if !ok {
+			continue
+		}
+		if changes[idx] != "" {
+			deployed = append(deployed, cfg.Name)
+		}
+		lockAfter.Configs[idx].DeployedChecksum = checksums[idx]
 	}
 
+	runGlobalHook(ctx, c.SourceCfgDir, HookPostDeploy, deployed, report)
 	return nil
 }
 
-func Copy(c *configuration.Configuration, configs []Config) error {
-	for _, cfg := range configs {
+// ok is false if a hook of the config failed, the checksum isn't recorded
+// then, so the hooks run again next time
+func deployCfg(ctx context.Context, c *configuration.Configuration, cfg Config, change string, report *Report) (ok bool, err error) {
+	env := configHookEnv(cfg, change)
+	if change != "" {
+		err = runHook(ctx, cfg.Name, cfg.From, HookPreDeploy, env, report)
+		if err != nil {
+			Logger.Error("not deploying the config, because its hook failed", "cfgName", cfg.Name, "hook", HookPreDeploy)
+			return false, nil
+		}
+	}
+
+	start := time.Now()
+	action := ActionSymlink
+	if c.CopyMode {
 		Logger.Info("copying", "from", cfg.From, "to", cfg.To)
-		err := copyCfg(cfg.From, cfg.To)
+		action = ActionCopy
+		err = copyCfg(cfg.From, cfg.To)
+	} else {
+		Logger.Info("symlinking", "from", cfg.From, "to", cfg.To)
+		err = symlink(cfg.From, cfg.To)
+	}
+	report.add(cfg.Name, action, cfg.To, "", start, err)
+	if err != nil {
+		return false, err
+	}
+
+	if change != "" {
+		err = runHook(ctx, cfg.Name, cfg.From, HookPostDeploy, env, report)
 		if err != nil {
-			return err
+			return false, nil
 		}
 	}
-	return nil
+	return true, nil
 }
 
-func Remove(c *configuration.Configuration, configs []Config) error {
+func Remove(ctx context.Context, c *configuration.Configuration, configs []Config, report *Report) error {
 	for _, cfg := range configs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		Logger.Info("removing config from target", "target", cfg.To)
+		start := time.Now()
 		err := removeCfg(cfg.To)
+		report.add(cfg.Name, ActionRemove, cfg.To, "", start, err)
 		if err != nil {
 			return err
 		}
@@ -38,48 +109,119 @@ func Remove(c *configuration.Configuration, configs []Config) error {
 	return nil
 }
 
-func Install(lock *Lockfile) map[string]installInfo {
+// installs packages of the given configs of the lockfile, once ctx is done no
+// more configs are started, only the ones that were installed completely are
+// returned
+func Install(ctx context.Context, lock *Lockfile, configs []Config, report *Report) map[string]installInfo {
 	forUpdate := make(map[string]installInfo)
-	for _, cfg := range lock.Configs {
-		if cfg.InstallInfo.IsInstalled {
-			Logger.Debug("skipping installation of already installed packages for config", "cfgName", cfg.Name)
-			continue
+	mu := sync.Mutex{}
+	forEachConfig(ctx, configs, func(cfg Config) {
+		info, ok := installCfg(ctx, lock, cfg, report)
+		if ok {
+			mu.Lock()
+			forUpdate[cfg.Name] = info
+			mu.Unlock()
 		}
+	})
+	return forUpdate
+}
 
-		info := installInfo{}
-		err := installDependencies(cfg.Requirements.Dependencies)
-		if err != nil {
-			Logger.Debug("something went wrong while installing dependencies, trying to continue", "cfgName", cfg.Name, "err", err)
-			continue
-		}
-		info.DependenciesInstalled = true
+// ok is false if nothing was installed or the installation failed
+func installCfg(ctx context.Context, lock *Lockfile, cfg Config, report *Report) (info installInfo, ok bool) {
+	depsOnly := cfg.Requirements.Install == nil
+	if cfg.InstallInfo.IsInstalled || (depsOnly && cfg.InstallInfo.DependenciesInstalled) {
+		Logger.Debug("skipping installation of already installed packages for config", "cfgName", cfg.Name)
+		return info, false
+	}
 
-		if cfg.Requirements.Install == nil {
-			Logger.Debug("there is no INSTALL file for this config (probably)", "cfgName", cfg.Name)
-			continue
+	err := installDependencies(ctx, lock, cfg.Name, cfg.Requirements.Dependencies, report)
+	if err != nil {
+		Logger.Error("something went wrong while installing dependencies, trying to continue", "cfgName", cfg.Name, "err", err)
+		return info, false
+	}
+	info.DependenciesInstalled = true
+
+	if depsOnly {
+		Logger.Debug("there is no INSTALL file for this config (probably)", "cfgName", cfg.Name)
+		// NOTE: still have to remember that the dependencies were
+		// installed, so that they can be uninstalled later
+		return info, true
+	}
+	Logger.Info("trying to install", "cfgName", cfg.Name)
+	start := time.Now()
+	res, err := install(ctx, cfg.Name, *cfg.Requirements.Install)
+	report.add(cfg.Name, ActionInstall, cfg.Requirements.Install.String(), res.Cmd, start, err)
+	if err != nil {
+		Logger.Error("something went wrong while installing, trying to continue", "cfgName", cfg.Name, "err", err)
+		return info, false
+	}
+	lock.recordInstall(cfg.Name, *cfg.Requirements.Install, res)
+	scope, err := cfg.Requirements.Install.Method.Scope(cfg.Requirements.Install.Options)
+	if err != nil {
+		Logger.Error("something went wrong while figuring out the installation scope, trying to continue", "cfgName", cfg.Name, "err", err)
+		return info, false
+	}
+	info.Scope = scope
+	info.InstallTime = now()
+	info.InstallInstruction = res.Cmd
+	info.InstalledFiles = res.Files
+	info.InstalledVersion = res.Version
+	info.ResolvedPkg = res.ResolvedPkg
+	info.SelectedLine = cfg.Requirements.Install.String()
+	info.IsInstalled = true
+	info.WasUninstalled = false
+	info.UninstallTime = ""
+	info.UninstallInstructions = []string{}
+	return info, true
+}
+
+// Upgrade upgrades packages of the configs that are installed, except the ones
+// named in installed (installed by Install during the same run), which are up
+// to date
+func Upgrade(ctx context.Context, lock *Lockfile, configs []Config, installed []string, report *Report) map[string]installInfo {
+	forUpdate := make(map[string]installInfo)
+	mu := sync.Mutex{}
+	forEachConfig(ctx, configs, func(cfg Config) {
+		if !cfg.InstallInfo.IsInstalled || cfg.Requirements.Install == nil {
+			return
 		}
-		Logger.Info("trying to install", "cfgName", cfg.Name)
-		cmd, err := install(*cfg.Requirements.Install)
+		if slices.Contains(installed, cfg.Name) {
+			Logger.Debug("skipping upgrade of a package installed during this run", "cfgName", cfg.Name)
+			return
+		}
+
+		info := cfg.InstallInfo
+		Logger.Info("trying to upgrade", "cfgName", cfg.Name)
+		start := time.Now()
+		res, err := upgrade(ctx, cfg.Name, *cfg.Requirements.Install)
+		report.add(cfg.Name, ActionUpgrade, cfg.Requirements.Install.String(), res.Cmd, start, err)
 		if err != nil {
-			Logger.Debug("something went wrong while installing dependencies, trying to continue", "cfgName", cfg.Name, "err", err)
-			continue
+			Logger.Error("something went wrong while upgrading, trying to continue", "cfgName", cfg.Name, "err", err)
+			return
 		}
+		lock.recordInstall(cfg.Name, *cfg.Requirements.Install, res)
 		info.InstallTime = now()
-		info.InstallInstruction = cmd
-		info.IsInstalled = true
-		info.WasUninstalled = false
-		info.UninstallTime = ""
-		info.UninstallInstructions = []string{}
+		info.InstallInstruction = res.Cmd
+		info.InstalledFiles = res.Files
+		info.InstalledVersion = res.Version
+		info.ResolvedPkg = res.ResolvedPkg
+		mu.Lock()
 		forUpdate[cfg.Name] = info
-	}
+		mu.Unlock()
+	})
 	return forUpdate
 }
 
-func Uninstall(lock *Lockfile) map[string]installInfo {
+func Uninstall(ctx context.Context, lock *Lockfile, report *Report) map[string]installInfo {
 	forUpdate := make(map[string]installInfo)
+	lock.UpdateDependencyOwners()
+	removed := map[string]bool{}
 
 	for _, cfg := range lock.HiddenConfigs {
-		info := uninstallForCfg(cfg)
+		if ctx.Err() != nil {
+			break
+		}
+		info := uninstallForCfg(ctx, lock, cfg, removed, report)
 		if info != nil {
 			forUpdate[cfg.Name] = *info
 		}
@@ -88,20 +230,112 @@ func Uninstall(lock *Lockfile) map[string]installInfo {
 	return forUpdate
 }
 
-func InstallGlobalDependencies(dependencies *[]GlobalDependency) error {
+func UpgradeGlobalDependencies(ctx context.Context, lock *Lockfile, report *Report) error {
+	Logger.Info("upgrading global dependencies")
+
+	for idx, dep := range lock.GlobalDependencies {
+		if !dep.InstallInfo.IsInstalled {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		start := time.Now()
+		info, res, err := upgradeGlobalDependency(ctx, dep)
+		report.add(GLOBAL_DEPS_OWNER, ActionUpgrade, dep.Instruction.String(), res.Cmd, start, err)
+		if err != nil {
+			return err
+		}
+		lock.GlobalDependencies[idx].InstallInfo = info
+		lock.recordInstall(GLOBAL_DEPS_OWNER, *dep.Instruction, res)
+	}
+
+	return nil
+}
+
+// UninstallRemovedGlobalDependencies uninstalls packages that were removed from
+// config/DEPENDENCIES since the lockfile `before` was saved, install info of
+// the uninstalled ones is moved to the history of removed global dependencies,
+// the ones that failed are kept there as installed, so that they are retried
+func UninstallRemovedGlobalDependencies(ctx context.Context, before, after *Lockfile, report *Report) {
+	after.UpdateDependencyOwners()
+
+	for _, entry := range planRemovedGlobalDependencies(before, after) {
+		if ctx.Err() != nil {
+			return
+		}
+		Logger.Info("uninstall plan", "cfgName", GLOBAL_DEPS_OWNER, "pkg", entry.Instruction.String(), "action", entry.Action, "reason", entry.Reason)
+
+		// NOTE: install info (e.g. installed files) only describes the
+		// package if it was alone on its line
+		prevInfo := installInfo{}
+		if entry.Whole {
+			prevInfo = entry.Dep.InstallInfo
+		}
+		if entry.Action != removePkg {
+			// NOTE: kept as installed, so that it's removed once the configs
+			// requiring it are gone, bash ones are never removed
+			if entry.Instruction.Method != i.Bash {
+				prevInfo.IsInstalled = true
+				after.setRemovedGlobalDependency(entry.Instruction, prevInfo)
+			}
+			continue
+		}
+
+		start := time.Now()
+		cmd, err := uninstall(ctx, GLOBAL_DEPS_OWNER, &entry.Instruction, prevInfo)
+		report.add(GLOBAL_DEPS_OWNER, ActionUninstall, entry.Instruction.String(), cmd, start, err)
+		if err != nil {
+			Logger.Error("something went wrong while uninstalling a removed global dependency, trying to continue", "pkg", entry.Instruction.String(), "err", err)
+			// NOTE: kept as installed, so that it's retried next time
+			prevInfo.IsInstalled = true
+			after.setRemovedGlobalDependency(entry.Instruction, prevInfo)
+			continue
+		}
+
+		info := entry.Dep.InstallInfo
+		info.IsInstalled = false
+		info.WasUninstalled = true
+		info.UninstallTime = now()
+		info.UninstallInstructions = append(slices.Clone(info.UninstallInstructions), cmd)
+		after.recordUninstall(entry.Instruction)
+		after.setRemovedGlobalDependency(entry.Instruction, info)
+	}
+}
+
+// replaces the entry of the package in RemovedGlobalDependencies (left there
+// by a removal that failed before), if there is one
+func (l *Lockfile) setRemovedGlobalDependency(inst installInstruction, info installInfo) {
+	l.RemovedGlobalDependencies = slices.DeleteFunc(slices.Clone(l.RemovedGlobalDependencies), func(dep GlobalDependency) bool {
+		return dep.Instruction.Equal(&inst)
+	})
+	l.RemovedGlobalDependencies = append(l.RemovedGlobalDependencies, GlobalDependency{
+		Instruction: &inst,
+		InstallInfo: info,
+	})
+}
+
+func InstallGlobalDependencies(ctx context.Context, lock *Lockfile, report *Report) error {
 	Logger.Info("installing global dependencies")
 
-	for idx, dep := range *dependencies {
+	for idx, dep := range lock.GlobalDependencies {
 		if dep.InstallInfo.IsInstalled {
 			Logger.Debug("skipping installation of an already installed global dependency", "pkgName", dep.Instruction.Pkg)
 			continue
 		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 
-		info, err := installGlobalDependency(dep)
+		start := time.Now()
+		info, res, err := installGlobalDependency(ctx, dep)
+		report.add(GLOBAL_DEPS_OWNER, ActionInstall, dep.Instruction.String(), res.Cmd, start, err)
 		if err != nil {
 			return err
 		}
-		(*dependencies)[idx].InstallInfo = info
+		lock.GlobalDependencies[idx].InstallInfo = info
+		lock.recordInstall(GLOBAL_DEPS_OWNER, *dep.Instruction, res)
 	}
 
 	return nil
@@ -0,0 +1,154 @@
+package lib
+
+import (
+	"blanktiger/hm/configuration"
+	i "blanktiger/hm/instructions"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Adopt moves a config (a directory or a single file) that lives directly in
+// the target directory (e.g. ~/.config/nvim) into the source directory, puts
+// it back in place (symlink or copy based on the mode) and adds it to the
+// lockfile, the config is moved back if anything fails after it was moved
+func Adopt(c *configuration.Configuration, lock *Lockfile, path string) (Config, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return Config{}, err
+	}
+	name := filepath.Base(path)
+
+	// NOTE: configs are always deployed to <targetdir>/<name>, so anything
+	// else couldn't be put back in the same place
+	if filepath.Dir(path) != filepath.Clean(c.TargetDir) {
+		return Config{}, fmt.Errorf("'%s' is not directly inside the target directory '%s'", path, c.TargetDir)
+	}
+	if name[0] == '.' {
+		return Config{}, fmt.Errorf("'%s' would be treated as a hidden config", name)
+	}
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		return Config{}, err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return Config{}, fmt.Errorf("'%s' is a symlink, it's probably managed already", path)
+	}
+	if !info.IsDir() && !info.Mode().IsRegular() {
+		return Config{}, fmt.Errorf("'%s' is neither a directory nor a regular file", path)
+	}
+	if !isConfigEntry(fs.FileInfoToDirEntry(info)) {
+		return Config{}, fmt.Errorf("'%s' wouldn't be treated as a config, names in capitals and git's files are reserved", name)
+	}
+
+	from := c.SourceCfgDir + "/" + name
+	for _, existing := range []string{from, c.SourceCfgDir + "/." + name} {
+		if _, err := os.Lstat(existing); err == nil {
+			return Config{}, fmt.Errorf("config '%s' already exists in the source directory", name)
+		}
+	}
+
+	Logger.Info("adopting", "from", path, "to", from)
+	err = move(path, from)
+	if err != nil {
+		return Config{}, err
+	}
+
+	cfg, stub, err := placeAdopted(c, name, from, path, info.IsDir())
+	if err != nil {
+		Logger.Error("adopting failed, moving the config back", "from", from, "to", path, "err", err)
+		return Config{}, errors.Join(err, unadopt(from, path, stub))
+	}
+	if !ContainsConfig(lock.Configs, cfg) {
+		lock.AddConfig(cfg)
+	}
+	return cfg, nil
+}
+
+// everything Adopt does after moving the config into the source directory,
+// stub is the path of the generated INSTALL (empty if there's none)
+func placeAdopted(c *configuration.Configuration, name, from, to string, isDir bool) (cfg Config, stub string, err error) {
+	// NOTE: single file configs can't have INSTALL
+	if c.DetectInstall && isDir {
+		stub, err = writeStubInstall(from, name)
+		if err != nil {
+			return Config{}, stub, err
+		}
+	}
+
+	if c.CopyMode {
+		err = copyCfg(from, to)
+	} else {
+		err = symlink(from, to)
+	}
+	if err != nil {
+		return Config{}, stub, err
+	}
+
+	var requirements *requirements
+	if isDir {
+		requirements, err = ParseRequirements(from)
+		if err != nil {
+			return Config{}, stub, err
+		}
+	}
+	return NewConfig(name, from, to, requirements), stub, nil
+}
+
+// undoes Adopt, whatever is at `to` was put there by Adopt so it's removed
+// before the config is moved back
+func unadopt(from, to, stub string) error {
+	errs := []error{}
+	if stub != "" {
+		errs = append(errs, os.Remove(stub))
+	}
+	err := removeCfg(to)
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	return errors.Join(append(errs, move(from, to))...)
+}
+
+// renames if possible, falls back to copying when the source and target
+// directories are on different filesystems
+func move(from, to string) error {
+	err := os.Rename(from, to)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	err = copyCfg(from, to)
+	if err != nil {
+		return err
+	}
+	return removeCfg(from)
+}
+
+// writes an INSTALL file with the package that provides the binary named like
+// the config, nothing is written if no package manager knows about it, path is
+// empty then
+func writeStubInstall(dir, name string) (path string, err error) {
+	path = dir + INSTALL_PATH_POSTFIX
+	if _, err := os.Stat(path); err == nil {
+		Logger.Debug("INSTALL already exists, not generating one", "path", path)
+		return "", nil
+	}
+
+	method, pkg, found := i.FindBinaryOwner(name)
+	if !found {
+		Logger.Info("couldn't find a package providing the binary, not generating INSTALL", "binary", name)
+		return "", nil
+	}
+
+	inst := installInstruction{Method: method, Pkg: pkg}
+	Logger.Info("generating INSTALL", "path", path, "instruction", inst.String())
+	err = os.WriteFile(path, []byte(inst.String()+"\n"), 0644)
+	if err != nil {
+		return "", err
+	}
+	return path, nil
+}
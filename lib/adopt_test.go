@@ -0,0 +1,94 @@
+package lib
+
+import (
+	"blanktiger/hm/configuration"
+	"io"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdopt(t *testing.T) {
+	Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	c := &configuration.Configuration{SourceCfgDir: t.TempDir(), TargetDir: t.TempDir()}
+	target := c.TargetDir + "/nvim"
+	assert.NoError(t, os.MkdirAll(target, 0755))
+	assert.NoError(t, os.WriteFile(target+"/init.lua", []byte("-- config"), 0644))
+	lock := newLockfile()
+
+	cfg, err := Adopt(c, &lock, target)
+
+	assert.NoError(t, err)
+	assert.Equal(t, c.SourceCfgDir+"/nvim", cfg.From)
+	assert.Equal(t, target, cfg.To)
+	txt, err := os.ReadFile(c.SourceCfgDir + "/nvim/init.lua")
+	assert.NoError(t, err)
+	assert.Equal(t, "-- config", string(txt))
+	link, err := isSymlink(target)
+	assert.NoError(t, err)
+	assert.True(t, link)
+	assert.Len(t, lock.Configs, 1)
+
+	_, err = Adopt(c, &lock, target)
+	assert.Error(t, err, "adopting an already symlinked config must fail")
+}
+
+func TestAdoptRejectsPathsOutsideTargetDir(t *testing.T) {
+	Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	c := &configuration.Configuration{SourceCfgDir: t.TempDir(), TargetDir: t.TempDir()}
+	nested := c.TargetDir + "/nvim/lua"
+	assert.NoError(t, os.MkdirAll(nested, 0755))
+	reserved := c.TargetDir + "/README.md"
+	assert.NoError(t, os.WriteFile(reserved, []byte(""), 0644))
+	lock := newLockfile()
+
+	_, err := Adopt(c, &lock, nested)
+	assert.Error(t, err)
+	_, err = Adopt(c, &lock, reserved)
+	assert.Error(t, err, "files named in capitals aren't configs")
+	assert.Empty(t, lock.Configs)
+}
+
+func TestAdoptFile(t *testing.T) {
+	Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	c := &configuration.Configuration{SourceCfgDir: t.TempDir(), TargetDir: t.TempDir(), CopyMode: true}
+	target := c.TargetDir + "/starship.toml"
+	assert.NoError(t, os.WriteFile(target, []byte("add_newline = false"), 0644))
+	lock := newLockfile()
+
+	cfg, err := Adopt(c, &lock, target)
+
+	assert.NoError(t, err)
+	assert.Equal(t, c.SourceCfgDir+"/starship.toml", cfg.From)
+	txt, err := os.ReadFile(cfg.From)
+	assert.NoError(t, err)
+	assert.Equal(t, "add_newline = false", string(txt))
+	txt, err = os.ReadFile(target)
+	assert.NoError(t, err)
+	assert.Equal(t, "add_newline = false", string(txt))
+	assert.Len(t, lock.Configs, 1)
+}
+
+func TestAdoptMovesTheConfigBackOnFailure(t *testing.T) {
+	Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	c := &configuration.Configuration{SourceCfgDir: t.TempDir(), TargetDir: t.TempDir()}
+	target := c.TargetDir + "/nvim"
+	assert.NoError(t, os.MkdirAll(target, 0755))
+	assert.NoError(t, os.WriteFile(target+"/init.lua", []byte("-- config"), 0644))
+	assert.NoError(t, os.WriteFile(target+"/DEPENDENCIES", []byte("unknown:pkg"), 0644))
+	lock := newLockfile()
+
+	_, err := Adopt(c, &lock, target)
+
+	assert.Error(t, err)
+	assert.NoDirExists(t, c.SourceCfgDir+"/nvim")
+	link, err := isSymlink(target)
+	assert.NoError(t, err)
+	assert.False(t, link)
+	txt, err := os.ReadFile(target + "/init.lua")
+	assert.NoError(t, err)
+	assert.Equal(t, "-- config", string(txt))
+	assert.Empty(t, lock.Configs)
+}
@@ -2,8 +2,9 @@ package lib
 
 import (
 	i "blanktiger/hm/instructions"
+	"errors"
 	"fmt"
-	"io"
+	"maps"
 	"os"
 	"slices"
 	"strings"
@@ -15,6 +16,13 @@ type Config struct {
 	To           string       `json:"to"`
 	Requirements requirements `json:"requirements"`
 	InstallInfo  installInfo  `json:"installInfo"`
+	// checksum of the source directory when the config was last deployed,
+	// deploy hooks only run when it changes (see sourceChecksum)
+	DeployedChecksum string `json:"deployedChecksum,omitempty"`
+	// systemd user units declared in UNITS
+	Units []Unit `json:"units,omitempty"`
+	// units as they were last applied by ApplyUnits
+	AppliedUnits []Unit `json:"appliedUnits,omitempty"`
 }
 
 type installInfo struct {
@@ -27,6 +35,18 @@ type installInfo struct {
 	WasUninstalled        bool     `json:"wasUninstalled"`
 	UninstallTime         string   `json:"uninstallTime"`
 	UninstallInstructions []string `json:"uninstallInstructions"`
+
+	// only set for methods that can install packages per user or system wide
+	Scope string `json:"scope,omitempty"`
+	// files put in place by hm itself, removed during uninstallation
+	InstalledFiles []string `json:"installedFiles,omitempty"`
+	// version reported by the package manager right after installation
+	InstalledVersion string `json:"installedVersion,omitempty"`
+	// the instruction line (with its condition) that was used for installation
+	SelectedLine string `json:"selectedLine,omitempty"`
+	// package name passed to the package manager, differs from the one in the
+	// instruction for aliased `system:` packages
+	ResolvedPkg string `json:"resolvedPkg,omitempty"`
 }
 
 func (i *installInfo) Equal(o *installInfo) bool {
@@ -34,7 +54,7 @@ func (i *installInfo) Equal(o *installInfo) bool {
 	if i.UninstallInstructions != nil && o.UninstallInstructions != nil {
 		uninstInstructionsMatch = slices.Equal(i.UninstallInstructions, o.UninstallInstructions)
 	}
-	return uninstInstructionsMatch && i.IsInstalled == o.IsInstalled && i.InstallTime == o.InstallTime && i.InstallInstruction == o.InstallInstruction && i.DependenciesInstalled == o.DependenciesInstalled && i.WasUninstalled == o.WasUninstalled && i.UninstallTime == o.UninstallTime
+	return uninstInstructionsMatch && i.IsInstalled == o.IsInstalled && i.InstallTime == o.InstallTime && i.InstallInstruction == o.InstallInstruction && i.DependenciesInstalled == o.DependenciesInstalled && i.WasUninstalled == o.WasUninstalled && i.UninstallTime == o.UninstallTime && i.Scope == o.Scope && slices.Equal(i.InstalledFiles, o.InstalledFiles) && i.InstalledVersion == o.InstalledVersion && i.SelectedLine == o.SelectedLine && i.ResolvedPkg == o.ResolvedPkg
 }
 
 func NewConfig(name, from, to string, reqs *requirements) Config {
@@ -82,8 +102,12 @@ func newRequirements() requirements {
 }
 
 type installInstruction struct {
-	Method i.InstallMethod `json:"method"`
-	Pkg    string          `json:"pkg"`
+	Method  i.InstallMethod `json:"method"`
+	Pkg     string          `json:"pkg"`
+	Options i.Options       `json:"options,omitempty"`
+	Version *i.Constraint   `json:"version,omitempty"`
+	// condition under which the instruction was selected, e.g. `os=darwin`
+	Condition string `json:"condition,omitempty"`
 }
 
 func newInstallInstruction() installInstruction {
@@ -93,9 +117,41 @@ func newInstallInstruction() installInstruction {
 	}
 }
 
+func (inst *installInstruction) Equal(o *installInstruction) bool {
+	versionMatch := inst.Version.IsEmpty() == o.Version.IsEmpty()
+	if versionMatch && !inst.Version.IsEmpty() {
+		versionMatch = *inst.Version == *o.Version
+	}
+	return inst.Method == o.Method && inst.Pkg == o.Pkg && maps.Equal(inst.Options, o.Options) && versionMatch && inst.Condition == o.Condition
+}
+
+// Prefix is the part of the instruction before the package, e.g. `flatpak(scope=user)`
+func (inst installInstruction) Prefix() string {
+	if len(inst.Options) == 0 {
+		return string(inst.Method)
+	}
+
+	opts := []string{}
+	for _, key := range slices.Sorted(maps.Keys(inst.Options)) {
+		opts = append(opts, key+"="+inst.Options[key])
+	}
+	return string(inst.Method) + "(" + strings.Join(opts, ",") + ")"
+}
+
+func (inst installInstruction) String() string {
+	res := inst.Prefix() + ":" + inst.Pkg
+	if !inst.Version.IsEmpty() {
+		res += inst.Version.String()
+	}
+	if inst.Condition != "" {
+		res = "[" + inst.Condition + "] " + res
+	}
+	return res
+}
+
 func parseInstallInstructions(path string) (res *installInstruction, err error) {
-	res = &installInstruction{}
-	file, err := os.Open(path + INSTALL_PATH_POSTFIX)
+	path = path + INSTALL_PATH_POSTFIX
+	txtBytes, err := os.ReadFile(path)
 	if err != nil {
 		// NOTE: file not existing is not an error in this case (can have config
 		// files without installation instructions obviously)
@@ -104,26 +160,38 @@ func parseInstallInstructions(path string) (res *installInstruction, err error)
 		}
 		return nil, err
 	}
-	defer file.Close()
 
-	{
-		txtBytes, err := io.ReadAll(file)
-		if err != nil {
-			return nil, err
-		}
+	// NOTE: the first line which condition matches this machine is used, but
+	// all of them are parsed, so that mistakes in the other ones are reported
+	// too
+	instructions, err := parseInstructionLines(path, string(txtBytes))
+	if len(instructions) > 0 {
+		res = &instructions[0]
+	}
+	return res, err
+}
 
-		txt := string(txtBytes)
-		if txt == "" {
-			return nil, nil
+// parses every non empty line, lines that are commented out or which condition
+// doesn't match are skipped, errors of all lines are returned together
+func parseInstructionLines(path, txt string) ([]installInstruction, error) {
+	res := []installInstruction{}
+	errs := []error{}
+	lineNr := 0
+	for line := range strings.Lines(txt) {
+		lineNr++
+		if strings.TrimSpace(line) == "" {
+			continue
 		}
-
-		res, err = parseInstallInstruction(txt)
+		inst, err := parseInstallInstruction(line)
 		if err != nil {
-			return nil, err
+			errs = append(errs, i.ParseError{Path: path, Line: lineNr, Text: strings.TrimSpace(line), Err: err})
+			continue
+		}
+		if inst != nil {
+			res = append(res, *inst)
 		}
 	}
-
-	return res, nil
+	return res, errors.Join(errs...)
 }
 
 func parseInstallInstruction(inst string) (res *installInstruction, err error) {
@@ -131,31 +199,108 @@ func parseInstallInstruction(inst string) (res *installInstruction, err error) {
 	res = &newII
 
 	// TODO: fix the skip install instruction/commenting install instructions
-	if inst[0:2] == "//" {
+	if strings.HasPrefix(inst, "//") {
 		Logger.Debug("skipping install instructions, because they are commented out", "instruction", inst)
 		return nil, nil
 	}
 
-	{
-		linesCount := strings.Count(inst, "\n")
-		Assert(linesCount <= 1, "think on how to handle multiple installation instructions if we want them in the future")
+	cond := i.Condition{}
+	if strings.HasPrefix(inst, "[") {
+		condTxt, rest, found := strings.Cut(inst[1:], "]")
+		if !found {
+			return nil, fmt.Errorf("unterminated condition in installation instruction: '%s'", inst)
+		}
+		cond, err = i.ParseCondition(condTxt)
+		if err != nil {
+			return nil, err
+		}
+		res.Condition = condTxt
+		inst = strings.TrimSpace(rest)
 	}
 
-	parts := strings.Split(inst, ":")
-	{
-		method := parts[0]
-		errMsg := fmt.Sprintf("must be an implemented, valid installation method, instead got: '%s'", method)
-		Assert(i.IsValidInstallationMethod(method), errMsg)
-		res.Method = i.InstallMethod(method)
+	methodTxt, pkg, found := strings.Cut(inst, ":")
+	if !found {
+		return nil, fmt.Errorf("installation instruction must be in the form of method:pkg, instead got: '%s'", inst)
+	}
+
+	if openIdx := strings.Index(methodTxt, "("); openIdx != -1 {
+		// options can contain ':' (think urls), so we have to look for the end
+		// of the options in the whole instruction
+		closeIdx := strings.Index(inst, "):")
+		if closeIdx == -1 {
+			return nil, fmt.Errorf("unterminated options in installation instruction: '%s'", inst)
+		}
+		opts, err := parseOptions(inst[openIdx+1 : closeIdx])
+		if err != nil {
+			return nil, err
+		}
+		res.Options = opts
+		methodTxt = inst[:openIdx]
+		pkg = inst[closeIdx+2:]
+	}
+
+	if !i.IsValidInstallationMethod(methodTxt) {
+		return nil, i.UnknownMethodError{Method: methodTxt}
+	}
+	res.Method = i.InstallMethod(methodTxt)
+	if _, err := res.Method.Retries(res.Options); err != nil {
+		return nil, err
 	}
 
 	{
-		pkg := parts[1]
 		res.Pkg = strings.Trim(pkg, "\n\t")
 	}
+
+	// NOTE: bash commands can contain anything, and lines with multiple
+	// packages can't be pinned, because there is only one version field
+	if res.Method != i.Bash && !strings.Contains(res.Pkg, " ") {
+		res.Pkg, res.Version, err = i.SplitConstraint(res.Pkg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !cond.Matches() {
+		Logger.Debug("skipping install instruction, because its condition doesn't match this machine", "instruction", res.String())
+		return nil, nil
+	}
 	return res, nil
 }
 
+func parseOptions(txt string) (i.Options, error) {
+	opts := i.Options{}
+	for opt := range strings.SplitSeq(txt, ",") {
+		key, value, found := strings.Cut(opt, "=")
+		key = strings.TrimSpace(key)
+		if !found || key == "" {
+			return nil, fmt.Errorf("options must be in the form of key=value, instead got: '%s'", opt)
+		}
+		opts[key] = strings.TrimSpace(value)
+	}
+	return opts, nil
+}
+
+// files of git itself that may be in the source directory
+var gitFiles = []string{".git", ".gitignore", ".gitattributes", ".gitmodules"}
+
+// isConfigEntry reports whether an entry of the source directory is a config,
+// besides directories single files can be configs too, but not the ones named
+// in capitals (hm's own like DEPENDENCIES, or README.md) and not git's
+func isConfigEntry(e os.DirEntry) bool {
+	name := e.Name()
+	if slices.Contains(gitFiles, name) {
+		return false
+	}
+	if e.IsDir() {
+		return true
+	}
+	if !e.Type().IsRegular() {
+		return false
+	}
+	base, _, _ := strings.Cut(strings.TrimPrefix(name, "."), ".")
+	return base != strings.ToUpper(base)
+}
+
 func createDepsPath(dir string) string {
 	return dir + DEPENDENCIES_PATH_POSTFIX
 }
@@ -163,7 +308,7 @@ func createDepsPath(dir string) string {
 func parseDependencies(path string) (res []installInstruction, err error) {
 	res = []installInstruction{}
 	path = createDepsPath(path)
-	file, err := os.Open(path)
+	txtBytes, err := os.ReadFile(path)
 	if err != nil {
 		// NOTE: file not existing is not an error in this case (can have
 		// config files without dependencies obviously)
@@ -172,27 +317,6 @@ func parseDependencies(path string) (res []installInstruction, err error) {
 		}
 		return nil, err
 	}
-	defer file.Close()
 
-	txtBytes, err := io.ReadAll(file)
-	if err != nil {
-		return nil, err
-	}
-
-	lines := strings.SplitSeq(string(txtBytes), "\n")
-	for line := range lines {
-		if line == "" {
-			continue
-		}
-		instructions, err := parseInstallInstruction(line)
-		if err != nil {
-			return nil, err
-		}
-		if instructions == nil {
-			continue
-		}
-		res = append(res, *instructions)
-	}
-
-	return res, err
+	return parseInstructionLines(path, string(txtBytes))
 }
@@ -0,0 +1,106 @@
+package lib
+
+import (
+	"blanktiger/hm/instructions"
+	"io"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseInstallInstructionWithOptions(t *testing.T) {
+	inst, err := parseInstallInstruction("flatpak(scope=user,remote=flathub):org.mozilla.firefox\n")
+
+	assert.NoError(t, err)
+	expected := installInstruction{
+		Method:  instructions.Flatpak,
+		Pkg:     "org.mozilla.firefox",
+		Options: instructions.Options{"scope": "user", "remote": "flathub"},
+	}
+	assert.Equal(t, expected, *inst)
+	assert.Equal(t, "flatpak(remote=flathub,scope=user):org.mozilla.firefox", inst.String())
+}
+
+func TestParseInstallInstructionKeepsColonsInPkg(t *testing.T) {
+	inst, err := parseInstallInstruction("bash:curl -fsSL https://example.com/install.sh | bash")
+
+	assert.NoError(t, err)
+	assert.Equal(t, instructions.Bash, inst.Method)
+	assert.Equal(t, "curl -fsSL https://example.com/install.sh | bash", inst.Pkg)
+	assert.Nil(t, inst.Options)
+}
+
+func TestParseInstallInstructionInvalidOptions(t *testing.T) {
+	_, err := parseInstallInstruction("flatpak(scope):org.mozilla.firefox")
+	assert.Error(t, err)
+
+	_, err = parseInstallInstruction("flatpak(scope=user:org.mozilla.firefox")
+	assert.Error(t, err)
+}
+
+func TestParseInstallInstructionWithVersionPin(t *testing.T) {
+	inst, err := parseInstallInstruction("cargo:ripgrep==14.1.0")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "ripgrep", inst.Pkg)
+	assert.Equal(t, &instructions.Constraint{Op: "==", Version: "14.1.0"}, inst.Version)
+	assert.Equal(t, "cargo:ripgrep==14.1.0", inst.String())
+
+	inst, err = parseInstallInstruction("system:git curl")
+	assert.NoError(t, err)
+	assert.Equal(t, "git curl", inst.Pkg)
+	assert.Nil(t, inst.Version)
+}
+
+func TestParseInstallInstructionsPicksFirstMatchingLine(t *testing.T) {
+	Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	dir := t.TempDir()
+	txt := "[os=plan9] brew:gnu-sed\n[os!=plan9] system:sed\nsystem:busybox\n"
+	err := os.WriteFile(dir+INSTALL_PATH_POSTFIX, []byte(txt), 0o644)
+	assert.NoError(t, err)
+
+	inst, err := parseInstallInstructions(dir)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "sed", inst.Pkg)
+	assert.Equal(t, "os!=plan9", inst.Condition)
+	assert.Equal(t, "[os!=plan9] system:sed", inst.String())
+}
+
+func TestParseInstallInstructionsReportsEveryBrokenLine(t *testing.T) {
+	Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	dir := t.TempDir()
+	txt := "x\nsystem:sed\ncargoo:ripgrep\n"
+	err := os.WriteFile(dir+INSTALL_PATH_POSTFIX, []byte(txt), 0o644)
+	assert.NoError(t, err)
+
+	_, err = parseInstallInstructions(dir)
+
+	var parseErr instructions.ParseError
+	assert.ErrorAs(t, err, &parseErr)
+	assert.Equal(t, dir+INSTALL_PATH_POSTFIX, parseErr.Path)
+	assert.Equal(t, 1, parseErr.Line)
+	assert.Equal(t, "x", parseErr.Text)
+
+	var methodErr instructions.UnknownMethodError
+	assert.ErrorAs(t, err, &methodErr)
+	assert.Equal(t, "cargoo", methodErr.Method)
+	assert.ErrorContains(t, err, INSTALL_PATH_POSTFIX+":3: unknown installation method 'cargoo': 'cargoo:ripgrep'")
+}
+
+func TestParseRequirementsJoinsInstallAndDependencyErrors(t *testing.T) {
+	Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	dir := t.TempDir()
+	err := os.WriteFile(dir+INSTALL_PATH_POSTFIX, []byte("nope:fish\n"), 0o644)
+	assert.NoError(t, err)
+	err = os.WriteFile(createDepsPath(dir), []byte("system:git\n:curl\n"), 0o644)
+	assert.NoError(t, err)
+
+	res, err := ParseRequirements(dir)
+
+	assert.Nil(t, res)
+	assert.ErrorContains(t, err, INSTALL_PATH_POSTFIX+":1:")
+	assert.ErrorContains(t, err, createDepsPath(dir)+":2: installation method is missing")
+}
@@ -0,0 +1,251 @@
+package lib
+
+import (
+	"blanktiger/hm/configuration"
+	i "blanktiger/hm/instructions"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+type Severity string
+
+const (
+	// hm would fail or do something wrong
+	SeverityError Severity = "error"
+	// hm works, but the result might not be what is expected
+	SeverityWarning Severity = "warning"
+)
+
+// checks done by `hm doctor`
+const (
+	CheckSource    = "source"
+	CheckSyntax    = "syntax"
+	CheckMethod    = "method"
+	CheckRoot      = "root"
+	CheckUninstall = "uninstall"
+	CheckTarget    = "target"
+	CheckLockfile  = "lockfile"
+	CheckUnits     = "units"
+)
+
+// Finding is a single problem found by Doctor
+type Finding struct {
+	Severity Severity `json:"severity"`
+	Check    string   `json:"check"`
+	// empty for global dependencies and problems not tied to a config
+	Config string `json:"config,omitempty"`
+	// file (with the line number if it's known) or directory of the problem
+	Location string `json:"location,omitempty"`
+	Msg      string `json:"message"`
+}
+
+type doctor struct {
+	c          *configuration.Configuration
+	lockBefore *Lockfile
+	findings   []Finding
+	// instructions run through the escalation tool
+	needRoot []string
+}
+
+// Doctor checks the source directory, the target directory and the lockfile of
+// the last run (lockBefore) without changing anything, initErr is the error of
+// loading METHODS, ALIASES and the profile (see instructions.Init)
+func Doctor(c *configuration.Configuration, lockBefore *Lockfile, initErr error) []Finding {
+	d := doctor{c: c, lockBefore: lockBefore, findings: []Finding{}}
+	d.addErrors("", c.SourceCfgDir, initErr)
+
+	entries, err := os.ReadDir(c.SourceCfgDir)
+	if err != nil {
+		d.add(SeverityError, CheckSource, "", c.SourceCfgDir, err.Error())
+		return d.findings
+	}
+
+	for _, e := range entries {
+		if !isConfigEntry(e) {
+			continue
+		}
+		d.checkConfig(e.Name(), e.IsDir())
+	}
+
+	depsPath := createDepsPath(c.SourceCfgDir)
+	for _, dep := range d.parse("", depsPath) {
+		d.checkMethod("", depsPath, dep)
+	}
+
+	if len(d.needRoot) > 0 && !i.EscalationAvailable() {
+		d.add(SeverityError, CheckRoot, "", "", fmt.Sprintf("%s is not available, but it's needed by %s", i.CurrentEscalation(), strings.Join(d.needRoot, ", ")))
+	}
+
+	d.checkLockfile()
+	return d.findings
+}
+
+func (d *doctor) add(severity Severity, check, cfgName, location, msg string) {
+	d.findings = append(d.findings, Finding{Severity: severity, Check: check, Config: cfgName, Location: location, Msg: msg})
+}
+
+// dirName is the name of the directory (or file) in the source directory,
+// starting with a dot for hidden configs
+func (d *doctor) checkConfig(dirName string, isDir bool) {
+	dir := d.c.SourceCfgDir + "/" + dirName
+	cfgName := strings.TrimPrefix(dirName, ".")
+	hidden := cfgName != dirName
+	if !isDir {
+		if !hidden {
+			d.checkTarget(cfgName)
+		}
+		return
+	}
+
+	installPath := dir + INSTALL_PATH_POSTFIX
+	install := d.parse(cfgName, installPath)
+	depsPath := createDepsPath(dir)
+	deps := d.parse(cfgName, depsPath)
+
+	// NOTE: packages of hidden configs are only ever uninstalled, so it
+	// doesn't matter whether they could be installed here
+	if !hidden {
+		// NOTE: only the first line matching this machine is used
+		if len(install) > 0 {
+			d.checkMethod(cfgName, installPath, install[0])
+		}
+		for _, dep := range deps {
+			d.checkMethod(cfgName, depsPath, dep)
+		}
+		d.checkTarget(cfgName)
+		d.checkUnits(cfgName, dir)
+	}
+
+	_, err := os.Stat(dir + UNINSTALL_PATH_POSTFIX)
+	hasUninstall := err == nil
+	if hasUninstall {
+		return
+	}
+	if len(install) > 0 && install[0].Method == i.Bash {
+		d.add(SeverityWarning, CheckUninstall, cfgName, installPath, "hm can't undo `bash:` installs, add an UNINSTALL script")
+	}
+	if hidden && len(install) == 0 && d.wasInstalled(cfgName) {
+		d.add(SeverityWarning, CheckUninstall, cfgName, dir, "the config is hidden, but it has neither INSTALL nor UNINSTALL, so its package can't be uninstalled")
+	}
+}
+
+// parses every line of an INSTALL or DEPENDENCIES file, the instructions which
+// condition matches this machine are returned
+func (d *doctor) parse(cfgName, path string) []installInstruction {
+	txt, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			d.add(SeverityError, CheckSyntax, cfgName, path, err.Error())
+		}
+		return nil
+	}
+
+	res, err := parseInstructionLines(path, string(txt))
+	d.addErrors(cfgName, path, err)
+	return res
+}
+
+// path is used for errors that don't know where they come from
+func (d *doctor) addErrors(cfgName, path string, err error) {
+	for _, err := range SplitErrors(err) {
+		var parseErr i.ParseError
+		if !errors.As(err, &parseErr) {
+			d.add(SeverityError, CheckSyntax, cfgName, path, err.Error())
+			continue
+		}
+
+		location := parseErr.Path
+		if parseErr.Line > 0 {
+			location += fmt.Sprintf(":%d", parseErr.Line)
+		}
+		msg := parseErr.Err.Error()
+		if parseErr.Text != "" {
+			msg += fmt.Sprintf(": '%s'", parseErr.Text)
+		}
+		d.add(SeverityError, CheckSyntax, cfgName, location, msg)
+	}
+}
+
+func (d *doctor) checkMethod(cfgName, path string, inst installInstruction) {
+	if inst.Method.AsksForCredentials() {
+		d.needRoot = append(d.needRoot, "'"+inst.String()+"'")
+	}
+	if inst.Method.IsAvailable() {
+		return
+	}
+
+	msg := fmt.Sprintf("'%s' is not available on this machine", inst.Method)
+	switch inst.Method {
+	case i.System:
+		msg = "no supported system package manager was found"
+	case i.Aur:
+		msg = "no supported AUR helper was found"
+	}
+	d.add(SeverityError, CheckMethod, cfgName, path, fmt.Sprintf("%s, needed by '%s'", msg, inst.String()))
+}
+
+func (d *doctor) checkTarget(cfgName string) {
+	to := d.c.TargetDir + "/" + cfgName
+	info, err := os.Lstat(to)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			d.add(SeverityError, CheckTarget, cfgName, to, err.Error())
+		}
+		return
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return
+	}
+	// NOTE: in copy mode the target is a copy made by hm
+	if d.lockBefore.Mode == Cpy && ContainsConfig(d.lockBefore.Configs, Config{Name: cfgName}) {
+		return
+	}
+	d.add(SeverityWarning, CheckTarget, cfgName, to, "exists and wasn't created by hm, deploying the config replaces it (see `hm adopt`)")
+}
+
+func (d *doctor) checkUnits(cfgName, dir string) {
+	units, err := parseUnits(dir)
+	d.addErrors(cfgName, dir+UNITS_PATH_POSTFIX, err)
+	if len(units) == 0 {
+		return
+	}
+	if _, err := exec.LookPath(systemctl[0]); err != nil {
+		d.add(SeverityError, CheckUnits, cfgName, dir+UNITS_PATH_POSTFIX, "systemctl is not available, but the config declares systemd user units")
+	}
+}
+
+func (d *doctor) wasInstalled(cfgName string) bool {
+	for _, cfg := range slices.Concat(d.lockBefore.Configs, d.lockBefore.HiddenConfigs) {
+		if cfg.Name == cfgName {
+			return cfg.InstallInfo.IsInstalled
+		}
+	}
+	return false
+}
+
+func (d *doctor) checkLockfile() {
+	for _, cfg := range slices.Concat(d.lockBefore.Configs, d.lockBefore.HiddenConfigs) {
+		// NOTE: hiding and unhiding renames the source directory
+		found := false
+		for _, from := range []string{cfg.From, hideConfigPath(cfg.From), unhideConfigPath(cfg.From)} {
+			if _, err := os.Lstat(from); err == nil {
+				found = true
+				break
+			}
+		}
+		if !found {
+			d.add(SeverityWarning, CheckLockfile, cfg.Name, cfg.From, "the lockfile points at a source directory that doesn't exist anymore")
+		}
+	}
+}
+
+// HasErrors reports whether any of the findings is an error
+func HasErrors(findings []Finding) bool {
+	return slices.ContainsFunc(findings, func(f Finding) bool {
+		return f.Severity == SeverityError
+	})
+}
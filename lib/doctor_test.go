@@ -0,0 +1,80 @@
+package lib
+
+import (
+	"blanktiger/hm/configuration"
+	"blanktiger/hm/instructions"
+	"io"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func findingsOf(findings []Finding, check string) []Finding {
+	res := []Finding{}
+	for _, f := range findings {
+		if f.Check == check {
+			res = append(res, f)
+		}
+	}
+	return res
+}
+
+func writeCfg(t *testing.T, dir string, files map[string]string) {
+	assert.NoError(t, os.MkdirAll(dir, 0o755))
+	for name, content := range files {
+		assert.NoError(t, os.WriteFile(dir+"/"+name, []byte(content), 0o644))
+	}
+}
+
+func TestDoctor(t *testing.T) {
+	Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	instructions.Logger = Logger
+	c := &configuration.Configuration{SourceCfgDir: t.TempDir(), TargetDir: t.TempDir()}
+	writeCfg(t, c.SourceCfgDir+"/nvim", map[string]string{"INSTALL": "sytem:neovim\n"})
+	writeCfg(t, c.SourceCfgDir+"/starship", map[string]string{"INSTALL": "bash:curl -sS https://starship.rs/install.sh | sh\n"})
+	writeCfg(t, c.SourceCfgDir+"/.fish", map[string]string{})
+	writeCfg(t, c.TargetDir+"/nvim", map[string]string{"init.lua": "-- not managed"})
+	lockBefore := &Lockfile{
+		HiddenConfigs: []Config{{Name: "fish", From: c.SourceCfgDir + "/fish", InstallInfo: installInfo{IsInstalled: true}}},
+		Configs:       []Config{{Name: "zsh", From: c.SourceCfgDir + "/zsh"}},
+	}
+
+	findings := Doctor(c, lockBefore, nil)
+
+	assert.True(t, HasErrors(findings))
+	syntax := findingsOf(findings, CheckSyntax)
+	assert.Len(t, syntax, 1)
+	assert.Equal(t, "nvim", syntax[0].Config)
+	assert.Equal(t, c.SourceCfgDir+"/nvim/INSTALL:1", syntax[0].Location)
+	assert.Equal(t, "unknown installation method 'sytem': 'sytem:neovim'", syntax[0].Msg)
+
+	uninstall := findingsOf(findings, CheckUninstall)
+	assert.Len(t, uninstall, 2)
+	// NOTE: hidden configs come first, because configs are checked in
+	// the order of their directory names
+	assert.Equal(t, "fish", uninstall[0].Config, "hidden config without INSTALL")
+	assert.Equal(t, "starship", uninstall[1].Config, "bash installs need UNINSTALL")
+
+	target := findingsOf(findings, CheckTarget)
+	assert.Len(t, target, 1)
+	assert.Equal(t, c.TargetDir+"/nvim", target[0].Location)
+
+	lockfile := findingsOf(findings, CheckLockfile)
+	assert.Len(t, lockfile, 1)
+	assert.Equal(t, "zsh", lockfile[0].Config)
+}
+
+func TestDoctorAcceptsCopiesMadeByHm(t *testing.T) {
+	Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	instructions.Logger = Logger
+	c := &configuration.Configuration{SourceCfgDir: t.TempDir(), TargetDir: t.TempDir()}
+	writeCfg(t, c.SourceCfgDir+"/nvim", map[string]string{"UNINSTALL": "rm -rf ~/.local/share/nvim\n"})
+	writeCfg(t, c.TargetDir+"/nvim", map[string]string{})
+	lockBefore := &Lockfile{Mode: Cpy, Configs: []Config{{Name: "nvim", From: c.SourceCfgDir + "/nvim"}}}
+
+	findings := Doctor(c, lockBefore, nil)
+
+	assert.Empty(t, findings)
+}
@@ -0,0 +1,106 @@
+package lib
+
+import (
+	"blanktiger/hm/configuration"
+	i "blanktiger/hm/instructions"
+	"context"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+)
+
+// sudo forgets the credentials after 5 minutes by default
+const CREDENTIALS_REFRESH = time.Minute
+
+// AcquireRoot asks for the credentials of the escalation tool once, before
+// anything is installed or uninstalled, and keeps them from expiring until
+// release is called, nothing is asked if none of the packages the run is going
+// to work with needs root
+func AcquireRoot(ctx context.Context, c *configuration.Configuration, lock *Lockfile) (release func(), err error) {
+	install := (c.Install || c.OnlyInstall || c.Upgrade) && !c.OnlyUninstall
+	uninstall := (c.Uninstall || c.OnlyUninstall) && !c.OnlyInstall
+	return acquireRoot(ctx, lock.pendingInstructions(install, c.Upgrade, uninstall))
+}
+
+func acquireRoot(ctx context.Context, insts []installInstruction) (release func(), err error) {
+	release = func() {}
+	needsRoot := slices.ContainsFunc(insts, func(inst installInstruction) bool {
+		return inst.Method.AsksForCredentials()
+	})
+	cmd := i.CredentialsCmd()
+	if !needsRoot || cmd == "" {
+		return release, nil
+	}
+
+	Logger.Info("asking for credentials up front", "cmd", cmd)
+	splitCmd := strings.Split(cmd, " ")
+	execCmd := exec.CommandContext(ctx, splitCmd[0], splitCmd[1:]...)
+	execCmd.Stdin, execCmd.Stdout, execCmd.Stderr = os.Stdin, os.Stderr, os.Stderr
+	err = execCmd.Run()
+	if err != nil {
+		return release, err
+	}
+
+	done := make(chan struct{})
+	go keepCredentials(ctx, done)
+	return func() { close(done) }, nil
+}
+
+func keepCredentials(ctx context.Context, done chan struct{}) {
+	cmd := i.RefreshCredentialsCmd()
+	if cmd == "" {
+		return
+	}
+
+	ticker := time.NewTicker(CREDENTIALS_REFRESH)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := output(cmd)
+			if err != nil {
+				Logger.Warn("couldn't refresh the credentials, you might be asked for them again", "cmd", cmd, "err", err)
+			}
+		}
+	}
+}
+
+// instructions of the packages which are going to be installed, upgraded or
+// uninstalled (roughly, it's fine to include a few more)
+func (l *Lockfile) pendingInstructions(install, upgrade, uninstall bool) []installInstruction {
+	res := []installInstruction{}
+	add := func(cfg Config) {
+		res = append(res, cfg.Requirements.Dependencies...)
+		if cfg.Requirements.Install != nil {
+			res = append(res, *cfg.Requirements.Install)
+		}
+	}
+
+	if install {
+		for _, dep := range l.GlobalDependencies {
+			if upgrade || !dep.InstallInfo.IsInstalled {
+				res = append(res, *dep.Instruction)
+			}
+		}
+		for _, cfg := range l.Configs {
+			if upgrade || !cfg.InstallInfo.IsInstalled {
+				add(cfg)
+			}
+		}
+	}
+
+	if uninstall {
+		for _, cfg := range l.HiddenConfigs {
+			if !cfg.InstallInfo.WasUninstalled {
+				add(cfg)
+			}
+		}
+	}
+	return res
+}
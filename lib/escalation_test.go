@@ -0,0 +1,33 @@
+package lib
+
+import (
+	"blanktiger/hm/instructions"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPendingInstructions(t *testing.T) {
+	fish := createCfg("fish")
+	fish.Requirements.Install = &installInstruction{Method: instructions.Pacman, Pkg: "fish"}
+	fish.InstallInfo.IsInstalled = true
+	rg := createCfg("rg")
+	rg.Requirements.Install = &installInstruction{Method: instructions.Cargo, Pkg: "ripgrep"}
+	tmux := createCfg("tmux")
+	tmux.Requirements.Install = &installInstruction{Method: instructions.Apt, Pkg: "tmux"}
+	lock := Lockfile{Configs: []Config{fish, rg}, HiddenConfigs: []Config{tmux}}
+
+	assert.Equal(t, []installInstruction{*rg.Requirements.Install}, lock.pendingInstructions(true, false, false))
+	assert.Len(t, lock.pendingInstructions(true, true, false), 2)
+	assert.Equal(t, []installInstruction{*tmux.Requirements.Install}, lock.pendingInstructions(false, false, true))
+}
+
+func TestAcquireRootSkipsUserLevelMethods(t *testing.T) {
+	insts := []installInstruction{{Method: instructions.Cargo, Pkg: "ripgrep"}, {Method: instructions.Bash, Pkg: "true"}}
+
+	// NOTE: it would ask for the password if it tried
+	release, err := acquireRoot(t.Context(), insts)
+
+	assert.NoError(t, err)
+	release()
+}
@@ -0,0 +1,191 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var (
+	dirtySourceRepoErr    = errors.New("source repository has uncommitted changes, commit them or pass --force")
+	divergedSourceRepoErr = errors.New("source repository diverged from its remote, reconcile it manually or pass --force")
+)
+
+// runs git in the given directory and returns its trimmed stdout
+func git(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	Logger.Debug("ran git", "dir", dir, "args", args, "err", err)
+	if err != nil {
+		return "", fmt.Errorf("git %s failed: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func isGitRepo(dir string) bool {
+	_, err := os.Stat(dir + "/.git")
+	return err == nil
+}
+
+func gitInit(dir string) error {
+	if isGitRepo(dir) {
+		Logger.Debug("source directory is a git repository already", "dir", dir)
+		return nil
+	}
+
+	if _, err := exec.LookPath("git"); err != nil {
+		Logger.Warn("git not found, not initializing a repository in the source directory", "dir", dir)
+		return nil
+	}
+
+	Logger.Info("initializing a git repository", "dir", dir)
+	_, err := git(dir, "init")
+	return err
+}
+
+// SyncSourceRepo clones the repository into dir on the first run, afterwards
+// it fetches and fast-forwards, a dirty or diverged working tree is an error
+// unless forced (in which case it's used as it is)
+func SyncSourceRepo(repo, dir string, force bool) error {
+	if !isGitRepo(dir) {
+		Logger.Info("cloning the source repository", "repo", repo, "dir", dir)
+		out, err := exec.Command("git", "clone", repo, dir).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("git clone failed: %w: %s", err, out)
+		}
+		return nil
+	}
+
+	Logger.Info("fetching the source repository", "repo", repo, "dir", dir)
+	_, err := git(dir, "fetch", "--quiet")
+	if err != nil {
+		return err
+	}
+
+	status, err := git(dir, "status", "--porcelain")
+	if err != nil {
+		return err
+	}
+	if status != "" {
+		if !force {
+			return dirtySourceRepoErr
+		}
+		Logger.Warn("source repository has uncommitted changes, using it as it is", "dir", dir)
+		return nil
+	}
+
+	// prints `<ahead>\t<behind>`
+	counts, err := git(dir, "rev-list", "--left-right", "--count", "HEAD...@{upstream}")
+	if err != nil {
+		return err
+	}
+	aheadTxt, behindTxt, _ := strings.Cut(counts, "\t")
+	ahead, _ := strconv.Atoi(aheadTxt)
+	behind, _ := strconv.Atoi(behindTxt)
+	Logger.Debug("source repository state", "ahead", ahead, "behind", behind)
+
+	if ahead > 0 {
+		if !force {
+			return divergedSourceRepoErr
+		}
+		Logger.Warn("source repository diverged from its remote, using it as it is", "dir", dir, "ahead", ahead, "behind", behind)
+		return nil
+	}
+	if behind == 0 {
+		return nil
+	}
+
+	Logger.Info("fast-forwarding the source repository", "dir", dir, "commits", behind)
+	_, err = git(dir, "merge", "--ff-only", "--quiet", "@{upstream}")
+	return err
+}
+
+// SourceCommit is the commit checked out in the source directory, empty if it
+// isn't a git repository
+func SourceCommit(dir string) string {
+	if !isGitRepo(dir) {
+		return ""
+	}
+	sha, err := git(dir, "rev-parse", "HEAD")
+	if err != nil {
+		Logger.Debug("couldn't read the commit of the source directory", "dir", dir, "err", err)
+		return ""
+	}
+	return sha
+}
+
+// CommitSelection stages and commits changes made to the config directory by
+// persisting the TUI selection, nothing happens if the source directory isn't
+// a git repository
+func CommitSelection(srcDir, srcCfgDir string, change SelectionChange) error {
+	if !isGitRepo(srcDir) {
+		Logger.Info("source directory isn't a git repository, not committing the selection", "dir", srcDir)
+		return nil
+	}
+	if change.IsEmpty() {
+		Logger.Debug("selection didn't change, nothing to commit")
+		return nil
+	}
+
+	// NOTE: only the renamed config directories and config/DEPENDENCIES are
+	// committed, so that unrelated changes (staged or not) stay as they are
+	paths := []string{}
+	for _, name := range slices.Concat(change.Hidden, change.Unhidden) {
+		paths = append(paths, name, "."+name)
+	}
+	if len(change.AddedDeps) > 0 || len(change.RemovedDeps) > 0 {
+		paths = append(paths, strings.TrimPrefix(DEPENDENCIES_PATH_POSTFIX, "/"))
+	}
+	paths, err := knownPaths(srcCfgDir, paths)
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		Logger.Debug("persisting the selection didn't change any files, nothing to commit")
+		return nil
+	}
+
+	_, err = git(srcCfgDir, slices.Concat([]string{"add", "-A", "--"}, paths)...)
+	if err != nil {
+		return err
+	}
+	staged, err := git(srcCfgDir, slices.Concat([]string{"diff", "--cached", "--name-only", "--"}, paths)...)
+	if err != nil {
+		return err
+	}
+	if staged == "" {
+		Logger.Debug("persisting the selection didn't change any files, nothing to commit")
+		return nil
+	}
+
+	subject, body := change.commitMessage()
+	Logger.Info("committing the selection", "dir", srcDir, "message", subject)
+	_, err = git(srcCfgDir, slices.Concat([]string{"commit", "--quiet", "-m", subject, "-m", body, "--"}, paths)...)
+	return err
+}
+
+// picks the paths (relative to dir) that exist or that git knows about, git
+// refuses pathspecs that match nothing
+func knownPaths(dir string, paths []string) ([]string, error) {
+	res := []string{}
+	for _, path := range paths {
+		if _, err := os.Lstat(dir + "/" + path); err == nil {
+			res = append(res, path)
+			continue
+		}
+		tracked, err := git(dir, "ls-files", "--", path)
+		if err != nil {
+			return nil, err
+		}
+		if tracked != "" {
+			res = append(res, path)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,142 @@
+package lib
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setupGit(t *testing.T) {
+	Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	t.Setenv("GIT_CONFIG_GLOBAL", "/dev/null")
+	t.Setenv("GIT_AUTHOR_NAME", "hm")
+	t.Setenv("GIT_AUTHOR_EMAIL", "hm@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "hm")
+	t.Setenv("GIT_COMMITTER_EMAIL", "hm@example.com")
+}
+
+// creates a bare repository with one commit and returns its path together
+// with a separate clone used to push new commits to it
+func newRemote(t *testing.T) (string, string) {
+	remote := t.TempDir() + "/remote.git"
+	_, err := git(t.TempDir(), "init", "--bare", "--initial-branch=main", remote)
+	assert.NoError(t, err)
+
+	upstream := t.TempDir() + "/upstream"
+	_, err = git(t.TempDir(), "clone", remote, upstream)
+	assert.NoError(t, err)
+	commitFile(t, upstream, "config/DEPENDENCIES", "system:git\n")
+	_, err = git(upstream, "push", "--quiet", "origin", "HEAD:main")
+	assert.NoError(t, err)
+	return remote, upstream
+}
+
+func commitFile(t *testing.T, dir, name, content string) {
+	assert.NoError(t, os.MkdirAll(filepath.Dir(dir+"/"+name), 0755))
+	assert.NoError(t, os.WriteFile(dir+"/"+name, []byte(content), 0644))
+	_, err := git(dir, "add", "-A")
+	assert.NoError(t, err)
+	_, err = git(dir, "commit", "--quiet", "-m", "update "+name)
+	assert.NoError(t, err)
+}
+
+func TestSyncSourceRepo(t *testing.T) {
+	setupGit(t)
+	remote, upstream := newRemote(t)
+	dir := t.TempDir() + "/source"
+
+	assert.NoError(t, SyncSourceRepo(remote, dir, false))
+	assert.FileExists(t, dir+"/config/DEPENDENCIES")
+	first := SourceCommit(dir)
+	assert.NotEmpty(t, first)
+
+	commitFile(t, upstream, "config/DEPENDENCIES", "system:git curl\n")
+	_, err := git(upstream, "push", "--quiet", "origin", "HEAD:main")
+	assert.NoError(t, err)
+
+	assert.NoError(t, SyncSourceRepo(remote, dir, false))
+	txt, err := os.ReadFile(dir + "/config/DEPENDENCIES")
+	assert.NoError(t, err)
+	assert.Equal(t, "system:git curl\n", string(txt))
+	assert.Equal(t, SourceCommit(upstream), SourceCommit(dir))
+}
+
+func TestSyncSourceRepoRefusesDirtyTree(t *testing.T) {
+	setupGit(t)
+	remote, _ := newRemote(t)
+	dir := t.TempDir() + "/source"
+	assert.NoError(t, SyncSourceRepo(remote, dir, false))
+
+	assert.NoError(t, os.WriteFile(dir+"/config/DEPENDENCIES", []byte("system:htop\n"), 0644))
+
+	assert.ErrorIs(t, SyncSourceRepo(remote, dir, false), dirtySourceRepoErr)
+	assert.NoError(t, SyncSourceRepo(remote, dir, true))
+}
+
+func TestSyncSourceRepoRefusesDivergedTree(t *testing.T) {
+	setupGit(t)
+	remote, upstream := newRemote(t)
+	dir := t.TempDir() + "/source"
+	assert.NoError(t, SyncSourceRepo(remote, dir, false))
+
+	commitFile(t, dir, "config/DEPENDENCIES", "system:htop\n")
+	commitFile(t, upstream, "config/DEPENDENCIES", "system:curl\n")
+	_, err := git(upstream, "push", "--quiet", "origin", "HEAD:main")
+	assert.NoError(t, err)
+
+	assert.ErrorIs(t, SyncSourceRepo(remote, dir, false), divergedSourceRepoErr)
+	assert.NoError(t, SyncSourceRepo(remote, dir, true))
+}
+
+func TestCommitSelection(t *testing.T) {
+	setupGit(t)
+	srcDir := t.TempDir()
+	_, err := git(srcDir, "init", "--quiet")
+	assert.NoError(t, err)
+	commitFile(t, srcDir, "config/DEPENDENCIES", "system:git htop\n")
+	assert.NoError(t, os.WriteFile(srcDir+"/notes.txt", []byte("unrelated"), 0644))
+
+	assert.NoError(t, os.WriteFile(srcDir+"/config/DEPENDENCIES", []byte("system:git\n"), 0644))
+	change := SelectionChange{RemovedDeps: []string{"system:htop"}}
+	assert.NoError(t, CommitSelection(srcDir, srcDir+"/config", change))
+
+	subject, err := git(srcDir, "log", "-1", "--format=%s")
+	assert.NoError(t, err)
+	assert.Equal(t, "hm: remove system:htop", subject)
+	status, err := git(srcDir, "status", "--porcelain")
+	assert.NoError(t, err)
+	assert.Equal(t, "?? notes.txt", status)
+}
+
+func TestCommitSelectionOnlyCommitsTheSelection(t *testing.T) {
+	setupGit(t)
+	srcDir := t.TempDir()
+	_, err := git(srcDir, "init", "--quiet")
+	assert.NoError(t, err)
+	commitFile(t, srcDir, "config/tmux/tmux.conf", "set -g mouse on\n")
+	commitFile(t, srcDir, "config/fish/config.fish", "set fish_greeting\n")
+
+	assert.NoError(t, os.Rename(srcDir+"/config/tmux", srcDir+"/config/.tmux"))
+	assert.NoError(t, os.WriteFile(srcDir+"/config/fish/config.fish", []byte("unrelated"), 0644))
+	assert.NoError(t, CommitSelection(srcDir, srcDir+"/config", SelectionChange{Hidden: []string{"tmux"}}))
+
+	files, err := git(srcDir, "show", "--name-status", "--format=%s", "HEAD")
+	assert.NoError(t, err)
+	assert.Equal(t, "hm: hide tmux\n\nR100\tconfig/tmux/tmux.conf\tconfig/.tmux/tmux.conf", files)
+	status, err := git(srcDir, "status", "--porcelain")
+	assert.NoError(t, err)
+	assert.Equal(t, "M config/fish/config.fish", status)
+}
+
+func TestCommitSelectionSkipsNonGitSourceDir(t *testing.T) {
+	setupGit(t)
+	srcDir := t.TempDir()
+
+	err := CommitSelection(srcDir, srcDir+"/config", SelectionChange{Hidden: []string{"tmux"}})
+
+	assert.NoError(t, err)
+}
@@ -0,0 +1,250 @@
+package lib
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Hook is an optional bash script run when something changed for a config,
+// either in the directory of the config or in the source directory (global
+// hooks run once per run)
+type Hook string
+
+const (
+	// a failing PRE_ hook skips the config (or the whole step for global
+	// hooks), failing POST_ hooks are only reported
+	HookPreDeploy    Hook = "PRE_DEPLOY"
+	HookPostDeploy   Hook = "POST_DEPLOY"
+	HookPostInstall  Hook = "POST_INSTALL"
+	HookPreUninstall Hook = "PRE_UNINSTALL"
+)
+
+var hooks = []Hook{HookPreDeploy, HookPostDeploy, HookPostInstall, HookPreUninstall}
+
+// what changed for a config, passed to the hooks in HM_CHANGE
+const (
+	ChangeAdded     = "added"
+	ChangeModified  = "modified"
+	ChangeMode      = "mode"
+	ChangeInstall   = "install"
+	ChangeUpgrade   = "upgrade"
+	ChangeUninstall = "uninstall"
+)
+
+// owner of the global hooks, e.g. `config/POST_DEPLOY`
+func globalHookOwner(hook Hook) string {
+	return "config/" + string(hook)
+}
+
+// runs the hook if it exists, the outcome is recorded in the report, env
+// (`NAME=value`) comes on top of HM_HOOK and HM_RUN_ID
+func runHook(ctx context.Context, owner, dir string, hook Hook, env []string, report *Report) error {
+	path := dir + "/" + string(hook)
+	if _, err := os.Stat(path); err != nil {
+		Logger.Debug("hook not found", "path", path)
+		return nil
+	}
+
+	env = append([]string{"HM_HOOK=" + string(hook), "HM_RUN_ID=" + report.RunId}, env...)
+	Logger.Info("running a hook", "owner", owner, "path", path, "env", env)
+	start := time.Now()
+	err := runWithEnv(ctx, owner, env, "bash", path)
+	report.add(owner, ActionHook, path, "bash "+path, start, err)
+	if err != nil {
+		Logger.Error("the hook failed", "owner", owner, "path", path, "err", err)
+	}
+	return err
+}
+
+// runs the global hook with the names of the changed configs in HM_CONFIGS,
+// nothing is run if nothing changed
+func runGlobalHook(ctx context.Context, srcDir string, hook Hook, changed []string, report *Report) error {
+	if len(changed) == 0 {
+		return nil
+	}
+	env := []string{"HM_CONFIGS=" + strings.Join(changed, " ")}
+	return runHook(ctx, globalHookOwner(hook), srcDir, hook, env, report)
+}
+
+func configHookEnv(cfg Config, change string) []string {
+	return []string{
+		"HM_CONFIG=" + cfg.Name,
+		"HM_CHANGE=" + change,
+		"HM_FROM=" + cfg.From,
+		"HM_TO=" + cfg.To,
+	}
+}
+
+// files of hm itself don't count as changes of the config
+func isHmFile(name string) bool {
+	hmFiles := []string{INSTALL_PATH_POSTFIX, UNINSTALL_PATH_POSTFIX, DEPENDENCIES_PATH_POSTFIX, UNITS_PATH_POSTFIX}
+	return slices.Contains(hmFiles, "/"+name) || slices.Contains(hooks, Hook(name))
+}
+
+// sourceChecksum hashes paths, modes and contents of everything in the source
+// directory of a config (symlinks aren't followed)
+func sourceChecksum(from string) (string, error) {
+	h := sha256.New()
+	err := filepath.WalkDir(from, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(from, path)
+		if err != nil {
+			return err
+		}
+		if filepath.Dir(rel) == "." && isHmFile(rel) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s\x00%s\x00", rel, info.Mode())
+
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(h, "%s\x00", target)
+		case info.Mode().IsRegular():
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			_, err = io.Copy(h, f)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// deployChange tells what changed for the config since the lockfile `before`
+// was saved, empty if nothing did
+func deployChange(before *Lockfile, mode Mode, cfg Config, checksum string) string {
+	idx := slices.IndexFunc(before.Configs, func(prev Config) bool { return prev.Name == cfg.Name })
+	switch {
+	case idx == -1 || before.Configs[idx].DeployedChecksum == "":
+		return ChangeAdded
+	case before.Mode != mode:
+		return ChangeMode
+	case before.Configs[idx].DeployedChecksum != checksum:
+		return ChangeModified
+	}
+	return ""
+}
+
+// installChange tells whether the package of the config was installed or
+// upgraded to a different version since the lockfile `before` was saved,
+// empty if it wasn't
+func installChange(before *Lockfile, cfg Config) string {
+	info := cfg.InstallInfo
+	if !info.IsInstalled && !info.DependenciesInstalled {
+		return ""
+	}
+	idx := slices.IndexFunc(before.Configs, func(prev Config) bool { return prev.Name == cfg.Name })
+	if idx == -1 {
+		return ChangeInstall
+	}
+	prev := before.Configs[idx].InstallInfo
+	switch {
+	case !prev.IsInstalled && !prev.DependenciesInstalled:
+		return ChangeInstall
+	case prev.InstallTime == info.InstallTime:
+		return ""
+	// NOTE: without versions the upgrade might have changed something
+	case prev.InstalledVersion != "" && prev.InstalledVersion == info.InstalledVersion:
+		return ""
+	}
+	return ChangeUpgrade
+}
+
+// RunInstallHooks runs POST_INSTALL of every config which package was
+// installed or upgraded since the lockfile `before` was saved, the global one
+// runs after them, also when only global dependencies were installed
+func RunInstallHooks(ctx context.Context, srcDir string, before, after *Lockfile, report *Report) {
+	changed := []string{}
+	for _, cfg := range after.Configs {
+		change := installChange(before, cfg)
+		if change == "" {
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		changed = append(changed, cfg.Name)
+		env := configHookEnv(cfg, change)
+		if cfg.Requirements.Install != nil {
+			env = append(env, "HM_PKG="+cfg.Requirements.Install.String())
+		}
+		env = append(env, "HM_VERSION="+cfg.InstallInfo.InstalledVersion)
+		runHook(ctx, cfg.Name, cfg.From, HookPostInstall, env, report)
+	}
+
+	for _, dep := range after.GlobalDependencies {
+		prev := slices.IndexFunc(before.GlobalDependencies, func(d GlobalDependency) bool {
+			return d.Instruction.Pkg == dep.Instruction.Pkg && d.InstallInfo.InstallTime == dep.InstallInfo.InstallTime
+		})
+		if dep.InstallInfo.IsInstalled && prev == -1 {
+			changed = append(changed, GLOBAL_DEPS_OWNER)
+			break
+		}
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	runGlobalHook(ctx, srcDir, HookPostInstall, changed, report)
+}
+
+// reports whether uninstallForCfg is going to do anything for the hidden config
+func uninstallPending(cfg Config) bool {
+	if cfg.InstallInfo.WasUninstalled {
+		return false
+	}
+	_, err := os.Stat(hideConfigPath(cfg.From) + UNINSTALL_PATH_POSTFIX)
+	return err == nil || cfg.Requirements.Install != nil || cfg.InstallInfo.DependenciesInstalled
+}
+
+// RunPreUninstallHook runs the global PRE_UNINSTALL before anything is
+// uninstalled, the names of the hidden configs (and GLOBAL_DEPS_OWNER if
+// global dependencies were removed) are in HM_CONFIGS, PRE_UNINSTALL of the
+// configs runs right before each of them is uninstalled
+func RunPreUninstallHook(ctx context.Context, srcDir string, before, after *Lockfile, report *Report) error {
+	changed := []string{}
+	for _, cfg := range after.HiddenConfigs {
+		if uninstallPending(cfg) {
+			changed = append(changed, cfg.Name)
+		}
+	}
+	after.UpdateDependencyOwners()
+	removed := slices.ContainsFunc(planRemovedGlobalDependencies(before, after), func(entry removedGlobalDep) bool {
+		return entry.Action == removePkg
+	})
+	if removed {
+		changed = append(changed, GLOBAL_DEPS_OWNER)
+	}
+
+	err := runGlobalHook(ctx, srcDir, HookPreUninstall, changed, report)
+	if err != nil {
+		return fmt.Errorf("global %s hook failed: %w", HookPreUninstall, err)
+	}
+	return nil
+}
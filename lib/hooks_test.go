@@ -0,0 +1,130 @@
+package lib
+
+import (
+	"blanktiger/hm/configuration"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// creates a config with a hook which appends its HM_CHANGE to <src>/<hook>.out
+func createHookedCfg(t *testing.T, src, target, name string, hook Hook, script string) Config {
+	from := src + "/" + name
+	assert.NoError(t, os.MkdirAll(from, 0o755))
+	assert.NoError(t, os.WriteFile(from+"/init.lua", []byte("-- "+name), 0o644))
+	hookScript := "echo \"$HM_CONFIG $HM_CHANGE\" >> " + src + "/" + string(hook) + ".out\n" + script
+	assert.NoError(t, os.WriteFile(from+"/"+string(hook), []byte(hookScript), 0o755))
+	return NewConfig(name, from, target+"/"+name, nil)
+}
+
+func readHookOutput(t *testing.T, src string, hook Hook) []string {
+	out, err := os.ReadFile(src + "/" + string(hook) + ".out")
+	if os.IsNotExist(err) {
+		return []string{}
+	}
+	assert.NoError(t, err)
+	return strings.Split(strings.TrimSpace(string(out)), "\n")
+}
+
+func TestDeployHooksOnlyRunWhenConfigChanged(t *testing.T) {
+	Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	prevStdout := Stdout
+	defer func() { Stdout = prevStdout }()
+	Stdout = io.Discard
+	src, target := t.TempDir(), t.TempDir()
+	c := &configuration.Configuration{SourceCfgDir: src, TargetDir: target}
+	globalHook := "echo \"$HM_CONFIGS\" >> " + src + "/global.out"
+	assert.NoError(t, os.WriteFile(src+"/"+string(HookPostDeploy), []byte(globalHook), 0o755))
+
+	before := newLockfile()
+	after := newLockfile()
+	after.Configs = []Config{
+		createHookedCfg(t, src, target, "nvim", HookPostDeploy, ""),
+		createHookedCfg(t, src, target, "fish", HookPostDeploy, ""),
+	}
+	err := Deploy(t.Context(), c, &before, &after, NewReport("apply"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"nvim added", "fish added"}, readHookOutput(t, src, HookPostDeploy))
+
+	before = after
+	after.Configs = []Config{createCfg("nvim"), createCfg("fish")}
+	after.Configs[0].From, after.Configs[0].To = before.Configs[0].From, before.Configs[0].To
+	after.Configs[1].From, after.Configs[1].To = before.Configs[1].From, before.Configs[1].To
+	CopyInstallInfo(&before, &after)
+	assert.NoError(t, os.WriteFile(src+"/fish/init.lua", []byte("changed"), 0o644))
+	err = Deploy(t.Context(), c, &before, &after, NewReport("apply"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"nvim added", "fish added", "fish modified"}, readHookOutput(t, src, HookPostDeploy))
+
+	global, err := os.ReadFile(src + "/global.out")
+	assert.NoError(t, err)
+	assert.Equal(t, "nvim fish\nfish\n", string(global))
+}
+
+func TestFailingPreDeploySkipsTheConfig(t *testing.T) {
+	Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	prevStdout := Stdout
+	defer func() { Stdout = prevStdout }()
+	Stdout = io.Discard
+	src, target := t.TempDir(), t.TempDir()
+	c := &configuration.Configuration{SourceCfgDir: src, TargetDir: target}
+
+	before := newLockfile()
+	after := newLockfile()
+	after.Configs = []Config{createHookedCfg(t, src, target, "nvim", HookPreDeploy, "exit 3")}
+	report := NewReport("apply")
+	err := Deploy(t.Context(), c, &before, &after, report)
+
+	assert.NoError(t, err)
+	assert.NoFileExists(t, target+"/nvim")
+	assert.Empty(t, after.Configs[0].DeployedChecksum)
+	assert.Len(t, report.Entries, 1)
+	assert.Equal(t, ActionHook, report.Entries[0].Action)
+	assert.Equal(t, 3, report.Entries[0].ExitCode)
+	assert.Equal(t, PartialFailureError{Failed: 1}, report.Err())
+}
+
+func TestSourceChecksumIgnoresHmFiles(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(dir+"/config.toml", []byte("a = 1"), 0o644))
+	before, err := sourceChecksum(dir)
+	assert.NoError(t, err)
+
+	assert.NoError(t, os.WriteFile(dir+"/INSTALL", []byte("cargo:ripgrep"), 0o644))
+	assert.NoError(t, os.WriteFile(dir+"/POST_DEPLOY", []byte("true"), 0o644))
+	after, err := sourceChecksum(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, before, after)
+
+	assert.NoError(t, os.WriteFile(dir+"/config.toml", []byte("a = 2"), 0o644))
+	after, err = sourceChecksum(dir)
+	assert.NoError(t, err)
+	assert.NotEqual(t, before, after)
+}
+
+func TestInstallChange(t *testing.T) {
+	installed := func(name, time, version string) Config {
+		cfg := createCfg(name)
+		cfg.InstallInfo = installInfo{IsInstalled: true, InstallTime: time, InstalledVersion: version}
+		return cfg
+	}
+	before := Lockfile{Configs: []Config{
+		installed("same", "t1", "1.0"),
+		installed("upgraded", "t1", "1.0"),
+		installed("reinstalled", "t1", "1.0"),
+		installed("unknown", "t1", ""),
+		createCfg("installed"),
+	}}
+
+	assert.Equal(t, "", installChange(&before, installed("same", "t1", "1.0")))
+	assert.Equal(t, ChangeUpgrade, installChange(&before, installed("upgraded", "t2", "1.1")))
+	assert.Equal(t, "", installChange(&before, installed("reinstalled", "t2", "1.0")))
+	assert.Equal(t, ChangeUpgrade, installChange(&before, installed("unknown", "t2", "")))
+	assert.Equal(t, ChangeInstall, installChange(&before, installed("installed", "t2", "")))
+	assert.Equal(t, ChangeInstall, installChange(&before, installed("new", "t2", "")))
+	assert.Equal(t, "", installChange(&before, createCfg("not installed")))
+}
@@ -0,0 +1,119 @@
+package lib
+
+import (
+	"blanktiger/hm/configuration"
+	i "blanktiger/hm/instructions"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const exampleGlobalDependencies = `// packages installed before any config, one instruction per line, e.g.
+// system:git curl
+// cargo:ripgrep
+`
+
+// InitSourceDir creates the source directory layout (optionally starting from
+// an existing source directory), initializes a git repository in it and writes
+// starter settings and profile, existing files are never overwritten
+func InitSourceDir(c *configuration.Configuration) error {
+	if c.InitFrom != "" {
+		err := fetchSourceDir(c.InitFrom, c.SourceDir)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := os.MkdirAll(c.SourceCfgDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	err = writeIfMissing(createDepsPath(c.SourceCfgDir), exampleGlobalDependencies)
+	if err != nil {
+		return err
+	}
+
+	err = gitInit(c.SourceDir)
+	if err != nil {
+		return err
+	}
+
+	starters := map[string]string{
+		c.SettingsPath: starterSettings(c),
+		c.ProfilePath:  starterProfile(),
+	}
+	for path, content := range starters {
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			return err
+		}
+		err = writeIfMissing(path, content)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clones git urls and copies local directories
+func fetchSourceDir(from, to string) error {
+	if _, err := os.Stat(to); err == nil {
+		return fmt.Errorf("source directory '%s' already exists, refusing to initialize it from '%s'", to, from)
+	}
+
+	if configuration.IsGitUrl(from) {
+		Logger.Info("cloning the source directory", "from", from, "to", to)
+		cmd := exec.Command("git", "clone", from, to)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		return cmd.Run()
+	}
+
+	Logger.Info("copying the source directory", "from", from, "to", to)
+	return copyCfg(from, to)
+}
+
+func writeIfMissing(path, content string) error {
+	if _, err := os.Stat(path); err == nil {
+		Logger.Info("file already exists, leaving it as is", "path", path)
+		return nil
+	}
+
+	Logger.Info("writing", "path", path)
+	return os.WriteFile(path, []byte(content), 0644)
+}
+
+func starterSettings(c *configuration.Configuration) string {
+	lines := []string{
+		"# settings of hm, flags take precedence over these",
+		fmt.Sprintf("sourcedir = %q", c.SourceDir),
+		fmt.Sprintf("targetdir = %q", c.TargetDir),
+		fmt.Sprintf("copy = %t", c.CopyMode),
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// the profile describes the machine as detected by `hm init`, it's read on
+// every run and takes precedence over the detection, so that e.g. a renamed
+// machine still matches the `[hostname=...]` conditions
+func starterProfile() string {
+	facts := i.CurrentFacts()
+	lines := []string{
+		"# description of this machine, detected by `hm init`, keys match the ones",
+		"# usable in instruction conditions, remove a line to detect it on every run",
+		fmt.Sprintf("os = %q", facts.OS),
+		fmt.Sprintf("distro = %q", facts.Distro),
+		fmt.Sprintf("arch = %q", facts.Arch),
+		fmt.Sprintf("hostname = %q", facts.Hostname),
+	}
+	if manager := i.SystemPkgManager(); manager != i.INVALID {
+		lines = append(lines, fmt.Sprintf("system_pkg_manager = %q", manager))
+	}
+	if manager := i.AurPkgManager(); manager != i.INVALID {
+		lines = append(lines, fmt.Sprintf("aur_pkg_manager = %q", manager))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
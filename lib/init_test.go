@@ -0,0 +1,66 @@
+package lib
+
+import (
+	"blanktiger/hm/configuration"
+	i "blanktiger/hm/instructions"
+	"io"
+	"log/slog"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newInitConfiguration(t *testing.T, sourceDir string) *configuration.Configuration {
+	return &configuration.Configuration{
+		SourceDir:    sourceDir,
+		SourceCfgDir: sourceDir + "/config",
+		TargetDir:    t.TempDir(),
+		SettingsPath: t.TempDir() + "/hm/settings.toml",
+		ProfilePath:  t.TempDir() + "/hm/profile.toml",
+	}
+}
+
+func TestInitSourceDir(t *testing.T) {
+	Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	i.Logger = Logger
+	i.GatherFacts()
+	c := newInitConfiguration(t, t.TempDir()+"/homecfg")
+
+	err := InitSourceDir(c)
+
+	assert.NoError(t, err)
+	deps, err := parseDependencies(c.SourceCfgDir)
+	assert.NoError(t, err)
+	assert.Empty(t, deps, "example dependencies must be commented out")
+	settings, err := os.ReadFile(c.SettingsPath)
+	assert.NoError(t, err)
+	assert.Contains(t, string(settings), `sourcedir = "`+c.SourceDir+`"`)
+	profile, err := os.ReadFile(c.ProfilePath)
+	assert.NoError(t, err)
+	assert.Contains(t, string(profile), `os = "`+runtime.GOOS+`"`)
+	assert.NoError(t, i.LoadProfile(c.ProfilePath), "the starter profile must be loadable")
+
+	// NOTE: running it again must keep what the user changed
+	assert.NoError(t, os.WriteFile(createDepsPath(c.SourceCfgDir), []byte("system:git\n"), 0644))
+	assert.NoError(t, InitSourceDir(c))
+	txt, err := os.ReadFile(createDepsPath(c.SourceCfgDir))
+	assert.NoError(t, err)
+	assert.Equal(t, "system:git\n", string(txt))
+}
+
+func TestInitSourceDirFromPath(t *testing.T) {
+	Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	existing := t.TempDir()
+	assert.NoError(t, os.MkdirAll(existing+"/config/fish", 0755))
+	assert.NoError(t, os.WriteFile(existing+"/config/fish/INSTALL", []byte("system:fish\n"), 0644))
+	c := newInitConfiguration(t, t.TempDir()+"/homecfg")
+	c.InitFrom = existing
+
+	err := InitSourceDir(c)
+
+	assert.NoError(t, err)
+	assert.FileExists(t, c.SourceCfgDir+"/fish/INSTALL")
+	assert.Error(t, InitSourceDir(c), "initializing from a path into an existing source directory must fail")
+}
@@ -0,0 +1,169 @@
+package lib
+
+import (
+	i "blanktiger/hm/instructions"
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// LedgerEntry remembers a package installed by hm, entries are never removed
+// from the ledger, so that packages can be found even after the config that
+// installed them was deleted from the source directory
+type LedgerEntry struct {
+	// config name or GLOBAL_DEPS_OWNER
+	Owner       string             `json:"owner"`
+	Instruction installInstruction `json:"instruction"`
+	Command     string             `json:"command"`
+	IsInstalled bool               `json:"isInstalled"`
+	InstallTime string             `json:"installTime"`
+	// needed to uninstall packages which instruction isn't in any config
+	// anymore
+	InstalledFiles []string `json:"installedFiles,omitempty"`
+	ResolvedPkg    string   `json:"resolvedPkg,omitempty"`
+	UninstallTime  string   `json:"uninstallTime,omitempty"`
+}
+
+// configs can be installed in parallel (see Jobs)
+var ledgerMu = sync.Mutex{}
+
+// recordInstall adds (or refreshes) an entry for every package of the
+// instruction
+func (l *Lockfile) recordInstall(owner string, inst installInstruction, res installResult) {
+	ledgerMu.Lock()
+	defer ledgerMu.Unlock()
+
+	singles := splitPackages(inst)
+	for _, single := range singles {
+		entry := LedgerEntry{
+			Owner:          owner,
+			Instruction:    single,
+			Command:        res.Cmd,
+			IsInstalled:    true,
+			InstallTime:    now(),
+			InstalledFiles: res.Files,
+		}
+		// NOTE: the resolved name describes the whole line, for split lines
+		// it is resolved again during uninstallation
+		if len(singles) == 1 {
+			entry.ResolvedPkg = res.ResolvedPkg
+		}
+
+		idx := slices.IndexFunc(l.Ledger, func(e LedgerEntry) bool {
+			return e.Owner == owner && packageKey(e.Instruction) == packageKey(single)
+		})
+		if idx == -1 {
+			l.Ledger = append(l.Ledger, entry)
+		} else {
+			l.Ledger[idx] = entry
+		}
+	}
+}
+
+// recordUninstall marks every package of the instruction as uninstalled,
+// regardless of which owner installed it
+func (l *Lockfile) recordUninstall(inst installInstruction) {
+	ledgerMu.Lock()
+	defer ledgerMu.Unlock()
+
+	for _, single := range splitPackages(inst) {
+		key := packageKey(single)
+		for idx, entry := range l.Ledger {
+			if entry.IsInstalled && packageKey(entry.Instruction) == key {
+				l.Ledger[idx].IsInstalled = false
+				l.Ledger[idx].UninstallTime = now()
+			}
+		}
+	}
+}
+
+// claimedPackages are the packages required by any config in the source
+// directory (hidden ones included) or by config/DEPENDENCIES
+func (l *Lockfile) claimedPackages() map[string]bool {
+	claimed := map[string]bool{}
+	claim := func(inst installInstruction) {
+		for _, single := range splitPackages(inst) {
+			claimed[packageKey(single)] = true
+		}
+	}
+
+	for _, dep := range l.GlobalDependencies {
+		claim(*dep.Instruction)
+	}
+	for _, cfg := range slices.Concat(l.Configs, l.HiddenConfigs) {
+		if cfg.Requirements.Install != nil {
+			claim(*cfg.Requirements.Install)
+		}
+		for _, dep := range cfg.Requirements.Dependencies {
+			claim(dep)
+		}
+	}
+	return claimed
+}
+
+// Orphans are the packages installed by hm that no config or global
+// dependency claims anymore, one entry per package (the most recently
+// installed one)
+func (l *Lockfile) Orphans() []LedgerEntry {
+	claimed := l.claimedPackages()
+
+	orphans := []LedgerEntry{}
+	seen := map[string]int{}
+	for _, entry := range l.Ledger {
+		key := packageKey(entry.Instruction)
+		if !entry.IsInstalled || claimed[key] {
+			continue
+		}
+		if idx, ok := seen[key]; ok {
+			if entry.InstallTime >= orphans[idx].InstallTime {
+				orphans[idx] = entry
+			}
+			continue
+		}
+		seen[key] = len(orphans)
+		orphans = append(orphans, entry)
+	}
+	return orphans
+}
+
+// UninstallOrphans uninstalls the given orphans and marks them as uninstalled
+// in the ledger, bash packages are skipped, because there is no way to
+// uninstall them
+func (l *Lockfile) UninstallOrphans(ctx context.Context, orphans []LedgerEntry) error {
+	insts := []installInstruction{}
+	for _, orphan := range orphans {
+		insts = append(insts, orphan.Instruction)
+	}
+	release, err := acquireRoot(ctx, insts)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	failed := 0
+	for _, orphan := range orphans {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if orphan.Instruction.Method == i.Bash {
+			Logger.Info("uninstall plan", "cfgName", orphan.Owner, "pkg", orphan.Instruction.String(), "action", keepPkg, "reason", "bash dependencies can't be uninstalled automatically")
+			continue
+		}
+
+		Logger.Info("uninstall plan", "cfgName", orphan.Owner, "pkg", orphan.Instruction.String(), "action", removePkg, "reason", "no config or global dependency claims it")
+		prevInfo := installInfo{InstalledFiles: orphan.InstalledFiles, ResolvedPkg: orphan.ResolvedPkg}
+		_, err := uninstall(ctx, orphan.Owner, &orphan.Instruction, prevInfo)
+		if err != nil {
+			Logger.Error("something went wrong while uninstalling an orphaned package, trying to continue", "pkg", orphan.Instruction.String(), "err", err)
+			failed++
+			continue
+		}
+		l.recordUninstall(orphan.Instruction)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("couldn't uninstall %d orphaned package(s)", failed)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package lib
+
+import (
+	"blanktiger/hm/instructions"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordInstallSplitsPackages(t *testing.T) {
+	lock := Lockfile{}
+	inst := installInstruction{Method: instructions.System, Pkg: "git curl"}
+
+	lock.recordInstall("fish", inst, installResult{Cmd: "sudo pacman -S --noconfirm git curl", ResolvedPkg: "git curl"})
+	lock.recordInstall("fish", inst, installResult{Cmd: "sudo pacman -S --noconfirm git curl", ResolvedPkg: "git curl"})
+
+	assert.Len(t, lock.Ledger, 2)
+	assert.Equal(t, "git", lock.Ledger[0].Instruction.Pkg)
+	assert.Equal(t, "curl", lock.Ledger[1].Instruction.Pkg)
+	assert.Equal(t, "", lock.Ledger[0].ResolvedPkg)
+	assert.True(t, lock.Ledger[1].IsInstalled)
+}
+
+func TestOrphans(t *testing.T) {
+	fish := createCfg("fish")
+	fish.Requirements.Install = &installInstruction{Method: instructions.System, Pkg: "fish"}
+	zsh := createCfg("zsh")
+	zsh.Requirements.Dependencies = []installInstruction{{Method: instructions.Cargo, Pkg: "starship"}}
+	lock := Lockfile{
+		GlobalDependencies: []GlobalDependency{{Instruction: &installInstruction{Method: instructions.System, Pkg: "git"}}},
+		Configs:            []Config{fish},
+		HiddenConfigs:      []Config{zsh},
+	}
+	lock.recordInstall("fish", installInstruction{Method: instructions.System, Pkg: "fish"}, installResult{})
+	lock.recordInstall("zsh", installInstruction{Method: instructions.Cargo, Pkg: "starship"}, installResult{})
+	lock.recordInstall(GLOBAL_DEPS_OWNER, installInstruction{Method: instructions.System, Pkg: "git htop"}, installResult{})
+	lock.recordInstall("tmux", installInstruction{Method: instructions.System, Pkg: "htop"}, installResult{})
+	lock.recordInstall("alacritty", installInstruction{Method: instructions.Brew, Pkg: "alacritty"}, installResult{})
+	lock.recordUninstall(installInstruction{Method: instructions.Brew, Pkg: "alacritty"})
+
+	orphans := lock.Orphans()
+
+	assert.Len(t, orphans, 1)
+	assert.Equal(t, "system:htop", packageKey(orphans[0].Instruction))
+}
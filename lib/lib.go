@@ -1,8 +1,9 @@
 package lib
 
 import (
+	i "blanktiger/hm/instructions"
+	"context"
 	"errors"
-	"fmt"
 	"io"
 	"log/slog"
 	"os"
@@ -14,9 +15,15 @@ import (
 
 var Logger *slog.Logger = nil
 
+// where output of the commands run by hm goes, main points it to stderr when
+// stdout is reserved for the report
+var Stdout io.Writer = os.Stdout
+
 const (
 	INSTALL_PATH_POSTFIX      = "/INSTALL"
+	UNINSTALL_PATH_POSTFIX    = "/UNINSTALL"
 	DEPENDENCIES_PATH_POSTFIX = "/DEPENDENCIES"
+	UNITS_PATH_POSTFIX        = "/UNITS"
 )
 
 func ParseGlobalDependencies(path string) (res []GlobalDependency, err error) {
@@ -34,16 +41,26 @@ func ParseGlobalDependencies(path string) (res []GlobalDependency, err error) {
 	return res, err
 }
 
-func installGlobalDependency(dep GlobalDependency) (info installInfo, err error) {
+func installGlobalDependency(ctx context.Context, dep GlobalDependency) (info installInfo, res installResult, err error) {
 	info, err = installInfo{}, nil
 
-	cmd, err := install(*dep.Instruction)
+	res, err = install(ctx, GLOBAL_DEPS_OWNER, *dep.Instruction)
+	if err != nil {
+		return info, res, err
+	}
+
+	scope, err := dep.Instruction.Method.Scope(dep.Instruction.Options)
 	if err != nil {
-		return info, err
+		return info, res, err
 	}
 
 	{
-		info.InstallInstruction = cmd
+		info.Scope = scope
+		info.InstallInstruction = res.Cmd
+		info.InstalledFiles = res.Files
+		info.InstalledVersion = res.Version
+		info.ResolvedPkg = res.ResolvedPkg
+		info.SelectedLine = dep.Instruction.String()
 		info.DependenciesInstalled = true
 		info.InstallTime = now()
 		info.IsInstalled = true
@@ -52,89 +69,222 @@ func installGlobalDependency(dep GlobalDependency) (info installInfo, err error)
 		info.UninstallTime = ""
 	}
 
-	return info, err
+	return info, res, err
 }
 
+// ParseRequirements parses INSTALL and DEPENDENCIES of a config, errors from
+// both files are returned together
 func ParseRequirements(path string) (res *requirements, err error) {
 	Logger.Debug("parsing requirements", "path", path)
 	res = &requirements{}
-	{
-		Logger.Debug("parsing installation instructions")
-		installationInstructions, err := parseInstallInstructions(path)
-		if err != nil {
-			return nil, err
-		}
-		if installationInstructions != nil {
-			res.Install = installationInstructions
-		}
+
+	Logger.Debug("parsing installation instructions")
+	installationInstructions, installErr := parseInstallInstructions(path)
+	if installationInstructions != nil {
+		res.Install = installationInstructions
 	}
 
-	{
-		Logger.Debug("parsing dependencies")
-		dependencies, err := parseDependencies(path)
-		if err != nil {
-			return nil, err
-		}
-		res.Dependencies = dependencies
+	Logger.Debug("parsing dependencies")
+	dependencies, depsErr := parseDependencies(path)
+	res.Dependencies = dependencies
+
+	err = errors.Join(installErr, depsErr)
+	if err != nil {
+		return nil, err
 	}
+	return res, nil
+}
 
-	return res, err
+// SplitErrors returns the errors joined with errors.Join (also nested ones)
+// one by one, nil gives an empty slice
+func SplitErrors(err error) []error {
+	if err == nil {
+		return []error{}
+	}
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return []error{err}
+	}
+
+	res := []error{}
+	for _, err := range joined.Unwrap() {
+		res = append(res, SplitErrors(err)...)
+	}
+	return res
 }
 
 func now() string {
 	return time.Now().UTC().Format(time.DateTime)
 }
 
-func installDependencies(dependencies []installInstruction) error {
+func installDependencies(ctx context.Context, lock *Lockfile, owner string, dependencies []installInstruction, report *Report) error {
 	for _, dep := range dependencies {
-		_, err := install(dep)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		start := time.Now()
+		res, err := install(ctx, owner, dep)
+		report.add(owner, ActionInstall, dep.String(), res.Cmd, start, err)
 		if err != nil {
 			return err
 		}
+		lock.recordInstall(owner, dep, res)
 	}
 	return nil
 }
 
-func install(inst installInstruction) (cmd string, err error) {
-	Assert(!inst.Method.IsEmpty(), fmt.Sprintf("at this point we should always have valid installation instructions, got: '%v'", inst))
+// what was done to install a package
+type installResult struct {
+	Cmd string
+	// files put in place by hm itself (not by a package manager)
+	Files []string
+	// empty if the installation method can't report it
+	Version string
+	// package name after resolving `system:` aliases
+	ResolvedPkg string
+}
+
+// owner is the config (or GLOBAL_DEPS_OWNER) the package is installed for, the
+// output of the command goes to its log
+func install(ctx context.Context, owner string, inst installInstruction) (res installResult, err error) {
+	if inst.Method.IsEmpty() {
+		return res, i.UnknownMethodError{}
+	}
+
+	if inst.Method == i.Release {
+		err = retry(ctx, owner, inst, func() error {
+			res, err = installRelease(ctx, inst)
+			return err
+		})
+		return res, err
+	}
 
-	Logger.Info("going to install a pkg", "method", inst.Method, "pkg", inst.Pkg)
-	cmd, err = inst.Method.CreateInstallCmd(inst.Pkg)
-	Logger.Info("got install cmd", "cmd", cmd)
+	Logger.Info("going to install a pkg", "method", inst.Method, "pkg", inst.Pkg, "version", inst.Version)
+	res.ResolvedPkg = inst.Method.ResolvePkg(inst.Pkg)
+	pkg, err := inst.Method.PinPkg(res.ResolvedPkg, inst.Version)
 	if err != nil {
-		return cmd, err
+		return res, err
+	}
+	res.Cmd, err = inst.Method.CreateInstallCmd(pkg, inst.Options)
+	Logger.Info("got install cmd", "cmd", res.Cmd)
+	if err != nil {
+		return res, err
 	}
 
-	err = execute(cmd)
-	return cmd, err
+	err = executeWithRetries(ctx, owner, inst, res.Cmd)
+	if err != nil {
+		return res, err
+	}
+	res.Version = recordInstalledVersion(inst, res.ResolvedPkg)
+	return res, nil
 }
 
-func execute(cmd string) error {
-	splitCmd := strings.Split(cmd, " ")
-	{
-		execCmd := exec.Command(splitCmd[0], splitCmd[1:]...)
-		execCmd.Stderr = os.Stderr
-		execCmd.Stdin = os.Stdin
-		execCmd.Stdout = os.Stdout
-		// BUG: if user does C-c here, then stdin/stdout/stderr might not get released
-		err := execCmd.Start()
-		if err != nil {
-			return nil
-		}
-		err = execCmd.Wait()
-		if err != nil {
+// pkg is the package name resolved for the system package manager
+func installedVersion(inst installInstruction, pkg string) (string, error) {
+	if inst.Method == i.Release {
+		return releaseVersion(inst), nil
+	}
+
+	cmd, err := inst.Method.CreateVersionCmd(pkg, inst.Options)
+	if err != nil {
+		return "", err
+	}
+	out, err := output(cmd)
+	if err != nil {
+		return "", err
+	}
+	return inst.Method.ParseVersionOutput(pkg, out)
+}
+
+func recordInstalledVersion(inst installInstruction, pkg string) string {
+	version, err := installedVersion(inst, pkg)
+	if err != nil {
+		Logger.Debug("couldn't find out the installed version", "pkg", inst.Pkg, "err", err)
+	}
+	return version
+}
+
+func upgradeGlobalDependency(ctx context.Context, dep GlobalDependency) (info installInfo, res installResult, err error) {
+	info, err = dep.InstallInfo, nil
+
+	res, err = upgrade(ctx, GLOBAL_DEPS_OWNER, *dep.Instruction)
+	if err != nil {
+		return info, res, err
+	}
+
+	info.InstallInstruction = res.Cmd
+	info.InstalledFiles = res.Files
+	info.InstalledVersion = res.Version
+	info.ResolvedPkg = res.ResolvedPkg
+	info.InstallTime = now()
+	info.IsInstalled = true
+	return info, res, err
+}
+
+func upgrade(ctx context.Context, owner string, inst installInstruction) (res installResult, err error) {
+	if inst.Method.IsEmpty() {
+		return res, i.UnknownMethodError{}
+	}
+
+	// NOTE: releases are pinned with a checksum, so upgrading is just
+	// downloading whatever the instruction points to again
+	if inst.Method == i.Release {
+		err = retry(ctx, owner, inst, func() error {
+			res, err = installRelease(ctx, inst)
 			return err
-		}
+		})
+		return res, err
+	}
+
+	Logger.Info("going to upgrade a pkg", "method", inst.Method, "pkg", inst.Pkg, "version", inst.Version)
+	res.ResolvedPkg = inst.Method.ResolvePkg(inst.Pkg)
+	pkg, err := inst.Method.PinPkg(res.ResolvedPkg, inst.Version)
+	if err != nil {
+		return res, err
+	}
+	res.Cmd, err = inst.Method.CreateUpgradeCmd(pkg, inst.Options)
+	Logger.Info("got upgrade cmd", "cmd", res.Cmd)
+	if err != nil {
+		return res, err
+	}
+
+	err = executeWithRetries(ctx, owner, inst, res.Cmd)
+	if err != nil {
+		return res, err
+	}
+	res.Version = recordInstalledVersion(inst, res.ResolvedPkg)
+	return res, nil
+}
+
+// failed attempts are retried, the package manager is only locked while the
+// command runs (see lockPkgManager)
+func executeWithRetries(ctx context.Context, owner string, inst installInstruction, cmd string) error {
+	return retry(ctx, owner, inst, func() error {
+		unlock := lockPkgManager(inst.Method)
+		defer unlock()
+		return execute(ctx, owner, cmd)
+	})
+}
+
+// runs the command without attaching it to the terminal and returns its stdout
+func output(cmd string) (string, error) {
+	splitCmd := strings.Split(cmd, " ")
+	out, err := exec.Command(splitCmd[0], splitCmd[1:]...).Output()
+	return string(out), err
+}
+
+func execute(ctx context.Context, owner, cmd string) error {
+	err := run(ctx, owner, strings.Split(cmd, " ")...)
+	if err != nil {
+		return err
 	}
 
 	Logger.Info("Successfully installed", "cmd", cmd)
 	return nil
 }
 
-func runUninstallScriptIfItExists(cfg Config, info *installInfo) {
-	idx := strings.LastIndex(cfg.From, "/")
-	from := cfg.From
-	path := from[:idx] + "/." + from[idx+1:] + "/UNINSTALL"
+func runUninstallScriptIfItExists(ctx context.Context, cfg Config, info *installInfo, report *Report) {
+	path := hideConfigPath(cfg.From) + UNINSTALL_PATH_POSTFIX
 	Logger.Debug("checking if UNINSTALL exists", "path", path)
 	f, err := os.Open(path)
 	if err != nil {
@@ -143,12 +293,11 @@ func runUninstallScriptIfItExists(cfg Config, info *installInfo) {
 	}
 	f.Close()
 	Logger.Info("running the /UNINSTALL script", "path", path)
-	cmd := exec.Command("bash", path)
-	cmd.Stdout = os.Stdout
-	cmd.Stdin = os.Stdin
-	cmd.Stderr = os.Stderr
-	err = cmd.Run()
+	start := time.Now()
+	err = run(ctx, cfg.Name, "bash", path)
+	report.add(cfg.Name, ActionUninstall, path, "bash "+path, start, err)
 	if err != nil {
+		Logger.Error("the UNINSTALL script failed", "path", path, "err", err)
 		return
 	}
 	{
@@ -162,60 +311,142 @@ func runUninstallScriptIfItExists(cfg Config, info *installInfo) {
 	}
 }
 
-func uninstallForCfg(cfg Config) *installInfo {
+// packages still required by active configs and global dependencies are taken
+// from lock.DependencyOwners, removed keeps track of dependencies already removed for other
+// hidden configs in this run
+func uninstallForCfg(ctx context.Context, lock *Lockfile, cfg Config, removed map[string]bool, report *Report) *installInfo {
 	if cfg.InstallInfo.WasUninstalled {
 		Logger.Debug("skipping uninstallation of already uninstalled packages for config", "cfgName", cfg.Name)
 		return nil
 	}
 
+	if uninstallPending(cfg) {
+		env := configHookEnv(cfg, ChangeUninstall)
+		if cfg.Requirements.Install != nil {
+			env = append(env, "HM_PKG="+cfg.Requirements.Install.String())
+		}
+		err := runHook(ctx, cfg.Name, hideConfigPath(cfg.From), HookPreUninstall, env, report)
+		if err != nil {
+			Logger.Error("not uninstalling the config, because its hook failed", "cfgName", cfg.Name, "hook", HookPreUninstall)
+			return nil
+		}
+	}
+
 	info := installInfo{}
-	runUninstallScriptIfItExists(cfg, &info)
+	runUninstallScriptIfItExists(ctx, cfg, &info, report)
 
-	if cfg.Requirements.Install == nil {
-		return &info
+	if cfg.Requirements.Install != nil && ctx.Err() == nil {
+		uninstallCfgPkg(ctx, lock, cfg, &info, report)
 	}
-	Logger.Info("uninstalling using inferred instructions (from the method found during installation)", "cfgName", cfg.Name)
 
-	cmd, err := uninstall(cfg.Requirements.Install)
-	if err != nil {
-		Logger.Debug("something went wrong while uninstalling dependencies using the autogenerated command based on the installation method, trying to continue", "cfgName", cfg.Name, "err", err)
-		return &info
+	if cfg.InstallInfo.DependenciesInstalled && ctx.Err() == nil {
+		uninstallCfgDependencies(ctx, lock, cfg, removed, &info, report)
 	}
 
+	return &info
+}
+
+func markAsUninstalled(info *installInfo) {
 	info.UninstallTime = now()
-	info.UninstallInstructions = append(info.UninstallInstructions, cmd)
 	info.WasUninstalled = true
 	info.InstallTime = ""
 	info.InstallInstruction = ""
+	info.InstalledFiles = nil
+	info.InstalledVersion = ""
+	info.ResolvedPkg = ""
 	info.IsInstalled = false
+}
 
-	return &info
+func uninstallCfgPkg(ctx context.Context, lock *Lockfile, cfg Config, info *installInfo, report *Report) {
+	plan := planPkgUninstall(*cfg.Requirements.Install, lock.DependencyOwners)
+	for _, entry := range plan {
+		if entry.Action == keepPkg {
+			Logger.Info("uninstall plan", "cfgName", cfg.Name, "pkg", entry.Instruction.String(), "action", entry.Action, "reason", entry.Reason)
+		}
+	}
+	inst, prevInfo := withoutKeptPkgs(*cfg.Requirements.Install, cfg.InstallInfo, plan)
+	if inst == nil {
+		markAsUninstalled(info)
+		return
+	}
 
-	// TODO: uninstall dependencies here
-	// info.DependenciesInstalled = false
+	Logger.Info("uninstalling using inferred instructions (from the method found during installation)", "cfgName", cfg.Name)
+	start := time.Now()
+	cmd, err := uninstall(ctx, cfg.Name, inst, prevInfo)
+	report.add(cfg.Name, ActionUninstall, inst.String(), cmd, start, err)
+	if err != nil {
+		Logger.Error("something went wrong while uninstalling using the autogenerated command based on the installation method, trying to continue", "cfgName", cfg.Name, "err", err)
+		return
+	}
 
+	info.UninstallInstructions = append(info.UninstallInstructions, cmd)
+	lock.recordUninstall(*inst)
+	markAsUninstalled(info)
 }
 
-func uninstall(inst *installInstruction) (cmd string, err error) {
-	Assert(!inst.Method.IsEmpty(), fmt.Sprintf("at this point we should always have valid uninstall instructions, got: '%v'", inst))
+func uninstallCfgDependencies(ctx context.Context, lock *Lockfile, cfg Config, removed map[string]bool, info *installInfo, report *Report) {
+	failed := false
+	for _, entry := range planDependenciesUninstall(cfg, lock.DependencyOwners) {
+		key := packageKey(entry.Instruction)
+		if entry.Action == removePkg && removed[key] {
+			entry.Action = keepPkg
+			entry.Reason = "already removed for another hidden config"
+		}
+		Logger.Info("uninstall plan", "cfgName", cfg.Name, "pkg", entry.Instruction.String(), "action", entry.Action, "reason", entry.Reason)
+		if entry.Action != removePkg {
+			continue
+		}
+		if ctx.Err() != nil {
+			failed = true
+			break
+		}
+
+		start := time.Now()
+		cmd, err := uninstall(ctx, cfg.Name, &entry.Instruction, installInfo{})
+		report.add(cfg.Name, ActionUninstall, entry.Instruction.String(), cmd, start, err)
+		if err != nil {
+			Logger.Error("something went wrong while uninstalling a dependency, trying to continue", "cfgName", cfg.Name, "pkg", entry.Instruction.String(), "err", err)
+			failed = true
+			continue
+		}
+		removed[key] = true
+		lock.recordUninstall(entry.Instruction)
+		info.UninstallInstructions = append(info.UninstallInstructions, cmd)
+	}
+
+	// NOTE: kept dependencies are not owned by this config anymore, so they
+	// don't count as installed for it
+	info.DependenciesInstalled = failed
+}
+
+func uninstall(ctx context.Context, owner string, inst *installInstruction, prevInfo installInfo) (cmd string, err error) {
+	if inst.Method.IsEmpty() {
+		return "", i.UnknownMethodError{}
+	}
+
+	if inst.Method == i.Release {
+		return uninstallRelease(prevInfo.InstalledFiles)
+	}
 
 	Logger.Info("going to uninstall a pkg", "method", inst.Method, "pkg", inst.Pkg)
-	cmd, err = inst.Method.CreateUninstallCmd(inst.Pkg)
+	// NOTE: the name used during installation takes precedence, aliases or
+	// the system package manager could have changed since then
+	pkg := prevInfo.ResolvedPkg
+	if pkg == "" {
+		pkg = inst.Method.ResolvePkg(inst.Pkg)
+	}
+	cmd, err = inst.Method.CreateUninstallCmd(pkg, inst.Options)
 	Logger.Info("got uninstall cmd", "cmd", cmd)
 	if err != nil {
 		return cmd, err
 	}
 
-	err = execute(cmd)
+	unlock := lockPkgManager(inst.Method)
+	defer unlock()
+	err = execute(ctx, owner, cmd)
 	return cmd, err
 }
 
-func Assert(condition bool, message string) {
-	if !condition {
-		panic(message)
-	}
-}
-
 func removeCfg(from string) error {
 	return os.RemoveAll(from)
 }
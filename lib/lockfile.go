@@ -2,8 +2,12 @@ package lib
 
 import (
 	"blanktiger/hm/configuration"
+	i "blanktiger/hm/instructions"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"maps"
 	"os"
 	"slices"
 	"strings"
@@ -24,6 +28,23 @@ type Lockfile struct {
 	GlobalDependencies []GlobalDependency `json:"globalDependencies"`
 	Configs            []Config           `json:"configs"`
 	HiddenConfigs      []Config           `json:"hiddenConfigs"`
+	// package (method:pkg) -> active configs and global dependencies that
+	// require it
+	DependencyOwners map[string][]string `json:"dependencyOwners"`
+	// history of global dependencies uninstalled after being removed from
+	// config/DEPENDENCIES
+	RemovedGlobalDependencies []GlobalDependency `json:"removedGlobalDependencies"`
+	// every package ever installed by hm, used to find orphaned packages
+	Ledger []LedgerEntry `json:"ledger"`
+	// commit of the source directory the configs were deployed from, empty if
+	// it isn't a git repository
+	SourceCommit string `json:"sourceCommit,omitempty"`
+	// run that saved this lockfile, output of its commands is kept in the
+	// logs directory under the same name
+	RunId string `json:"runId,omitempty"`
+	// packages that couldn't be installed or upgraded during the last run
+	// that managed packages, see `hm install --failed`
+	Failures []ReportEntry `json:"failures,omitempty"`
 }
 
 type GlobalDependency struct {
@@ -34,7 +55,7 @@ type GlobalDependency struct {
 func (d *GlobalDependency) Equal(o *GlobalDependency) bool {
 	instMatch := false
 	if d.Instruction != nil && o.Instruction != nil {
-		instMatch = *d.Instruction == *o.Instruction
+		instMatch = d.Instruction.Equal(o.Instruction)
 	}
 
 	return instMatch && d.InstallInfo.Equal(&o.InstallInfo)
@@ -90,30 +111,25 @@ func WereGlobalDependenciesInstalled(deps *[]GlobalDependency) bool {
 	return false
 }
 
-func writeGroupedGlobalDependenciesToFile(file *os.File, groupedDeps map[string][]GlobalDependency) error {
-	for method, deps := range groupedDeps {
-		serialized := serializeGlobalDeps(method, deps)
-		written, err := file.WriteString(serialized)
-		if err != nil {
-			return err
-		}
-		Assert(written == len(serialized), "We must write everything to the file")
-	}
-	return nil
-}
-
-func serializeGlobalDeps(method string, deps []GlobalDependency) string {
+// serializes deps that aren't in config/DEPENDENCIES yet, deps with the same
+// prefix share a line unless they're conditioned or pinned
+func serializeGlobalDeps(deps []GlobalDependency) string {
 	serialized := ""
-	if method != "bash" {
-		serialized = method + ":"
-		for _, dep := range deps {
-			serialized += dep.Instruction.Pkg + " "
-		}
-		serialized = serialized[:len(serialized)-1] + "\n"
-	} else {
-		for _, dep := range deps {
-			serialized += "bash:" + dep.Instruction.Pkg + "\n"
+	groupedDeps := make(map[string][]string)
+	for _, dep := range deps {
+		inst := dep.Instruction
+		// NOTE: a line with multiple packages can't hold a condition or
+		// version pin of a single one of them
+		if inst.Method == i.Bash || inst.Condition != "" || !inst.Version.IsEmpty() {
+			serialized += inst.String() + "\n"
+			continue
 		}
+		// NOTE: deps with different options can't be put on the same line
+		prefix := inst.Prefix()
+		groupedDeps[prefix] = append(groupedDeps[prefix], inst.Pkg)
+	}
+	for _, prefix := range slices.Sorted(maps.Keys(groupedDeps)) {
+		serialized += prefix + ":" + strings.Join(groupedDeps[prefix], " ") + "\n"
 	}
 	return serialized
 }
@@ -143,6 +159,12 @@ configs:
 		for idx := range to.Configs {
 			if cfgFrom.Name == to.Configs[idx].Name {
 				to.Configs[idx].InstallInfo = cfgFrom.InstallInfo
+				to.Configs[idx].AppliedUnits = cfgFrom.AppliedUnits
+				// NOTE: hidden configs were removed from the target, so
+				// they have to be deployed again
+				if ContainsConfig(from.Configs, cfgFrom) {
+					to.Configs[idx].DeployedChecksum = cfgFrom.DeployedChecksum
+				}
 				continue configs
 			}
 		}
@@ -150,11 +172,15 @@ configs:
 		for idx := range to.HiddenConfigs {
 			if cfgFrom.Name == to.HiddenConfigs[idx].Name {
 				to.HiddenConfigs[idx].InstallInfo = cfgFrom.InstallInfo
+				to.HiddenConfigs[idx].AppliedUnits = cfgFrom.AppliedUnits
 				continue configs
 			}
 		}
 	}
 
+	to.RemovedGlobalDependencies = slices.Clone(from.RemovedGlobalDependencies)
+	to.Ledger = slices.Clone(from.Ledger)
+
 	for _, depFrom := range from.GlobalDependencies {
 		for idx := range to.GlobalDependencies {
 			if depFrom.Instruction.Pkg == to.GlobalDependencies[idx].Instruction.Pkg {
@@ -190,50 +216,76 @@ func (l *Lockfile) PersistConfigSelection() error {
 	return nil
 }
 
+// PersistGlobalDepsSelection removes the deselected global dependencies from
+// config/DEPENDENCIES, the rest of the file (comments, lines for other
+// machines...) is kept as it is
 func (l Lockfile) PersistGlobalDepsSelection(srcDir string) error {
-	groupedDeps := make(map[string][]GlobalDependency)
-	for _, dep := range l.GlobalDependencies {
-		method := string(dep.Instruction.Method)
-		groupedDeps[method] = append(groupedDeps[method], dep)
-	}
-
 	path := createDepsPath(srcDir)
-	file, err := os.Create(path)
-	if err != nil {
+	txt, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
-	defer file.Close()
 
-	return writeGroupedGlobalDependenciesToFile(file, groupedDeps)
+	left := slices.Clone(l.GlobalDependencies)
+	serialized := ""
+	for line := range strings.Lines(string(txt)) {
+		line = strings.TrimSuffix(line, "\n") + "\n"
+		if strings.TrimSpace(line) == "" {
+			serialized += line
+			continue
+		}
+		inst, err := parseInstallInstruction(line)
+		if err != nil || inst == nil {
+			serialized += line
+			continue
+		}
+		idx := slices.IndexFunc(left, func(dep GlobalDependency) bool {
+			return dep.Instruction.Equal(inst)
+		})
+		if idx == -1 {
+			Logger.Debug("removing a deselected global dependency", "line", strings.TrimSpace(line))
+			continue
+		}
+		serialized += line
+		left = slices.Delete(left, idx, idx+1)
+	}
+	serialized += serializeGlobalDeps(left)
+
+	return os.WriteFile(path, []byte(serialized), 0o644)
 }
 
 func cfgIsHiddenBasedOnFrom(from string) bool {
 	lastSep := strings.LastIndex(from, "/")
 	dirName := from[lastSep+1:]
-	return dirName[0] == '.'
+	return strings.HasPrefix(dirName, ".")
 }
 
+// NOTE: callers check cfgIsHiddenBasedOnFrom first, already unhidden paths
+// are returned as they are
 func unhideConfigPath(from string) string {
 	lastSep := strings.LastIndex(from, "/")
 	dirName := from[lastSep+1:]
-	Assert(dirName[0] == '.', "Passed config must be hidden")
-	return from[:lastSep+1] + dirName[1:]
+	return from[:lastSep+1] + strings.TrimPrefix(dirName, ".")
 }
 
 func hideConfigPath(from string) string {
+	if cfgIsHiddenBasedOnFrom(from) {
+		return from
+	}
 	lastSep := strings.LastIndex(from, "/")
-	dirName := from[lastSep+1:]
-	Assert(dirName[0] != '.', "Passed config must not be hidden")
-	return from[:lastSep+1] + "." + dirName
+	return from[:lastSep+1] + "." + from[lastSep+1:]
 }
 
 func newLockfile() Lockfile {
 	return Lockfile{
-		Configs:            []Config{},
-		HiddenConfigs:      []Config{},
-		GlobalDependencies: []GlobalDependency{},
-		Mode:               Dev,
-		Version:            "0.1.0",
+		Configs:                   []Config{},
+		HiddenConfigs:             []Config{},
+		GlobalDependencies:        []GlobalDependency{},
+		DependencyOwners:          map[string][]string{},
+		RemovedGlobalDependencies: []GlobalDependency{},
+		Ledger:                    []LedgerEntry{},
+		Mode:                      Dev,
+		Version:                   "0.1.0",
 	}
 }
 
@@ -245,26 +297,40 @@ func CreateLockBasedOnConfigs(c *configuration.Configuration) (*Lockfile, error)
 
 	if err != nil {
 		Logger.Error("couldn't read dir", "err", err)
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("source directory '%s' doesn't exist, create it with `hm init`: %w", c.SourceCfgDir, err)
+		}
 		return nil, err
 	}
 
+	// NOTE: every config is parsed, so that all the broken instructions are
+	// reported at once
+	parseErrs := []error{}
 	for _, e := range entries {
-		if e.Type() != os.ModeDir {
+		if !isConfigEntry(e) {
 			continue
 		}
 
 		name := e.Name()
-		if name == ".git" {
-			continue
-		}
-
 		from := c.SourceCfgDir + "/" + name
 		to := c.TargetDir + "/" + name
 
-		requirements, err := ParseRequirements(from)
-		if err != nil {
-			Logger.Error("something went wrong while trying to parse requirements", "err", err)
-			return nil, err
+		// NOTE: single file configs can't have INSTALL, UNITS...
+		var requirements *requirements
+		units := []Unit{}
+		if e.IsDir() {
+			requirements, err = ParseRequirements(from)
+			if err != nil {
+				Logger.Debug("something went wrong while trying to parse requirements", "err", err)
+				parseErrs = append(parseErrs, err)
+				continue
+			}
+			units, err = parseUnits(from)
+			if err != nil {
+				Logger.Debug("something went wrong while trying to parse units", "err", err)
+				parseErrs = append(parseErrs, err)
+				continue
+			}
 		}
 
 		if name[0] == '.' {
@@ -274,13 +340,18 @@ func CreateLockBasedOnConfigs(c *configuration.Configuration) (*Lockfile, error)
 			toIfNotSkipped := c.TargetDir + "/" + nameIfNotSkipped
 			from := c.SourceCfgDir + "/" + name
 			config := NewConfig(nameIfNotSkipped, from, toIfNotSkipped, requirements)
+			config.Units = units
 			lockfile.AppendSkippedConfig(config)
 			continue
 		}
 
 		config := NewConfig(name, from, to, requirements)
+		config.Units = units
 		lockfile.AddConfig(config)
 	}
+	if err := errors.Join(parseErrs...); err != nil {
+		return nil, err
+	}
 
 	if c.CopyMode {
 		Logger.Debug("setting mode to cpy")
@@ -320,12 +391,8 @@ func (d *lockfileDiff) Save(path, indent string) error {
 	if err != nil {
 		return err
 	}
-	written, err := file.Write(toWrite)
-	if err != nil {
-		return err
-	}
-	Assert(written == len(toWrite), "must write what is given")
-	return nil
+	_, err = file.Write(toWrite)
+	return err
 }
 
 func DiffLocks(lockBefore, lockAfter Lockfile) lockfileDiff {
@@ -382,6 +449,14 @@ func DiffLocks(lockBefore, lockAfter Lockfile) lockfileDiff {
 	}
 }
 
+func ReadLockfile(path string) (*Lockfile, error) {
+	txt, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseLockfile(txt)
+}
+
 func ReadOrCreateLockfile(path string) (*Lockfile, error) {
 	file, err := os.Open(path)
 	if err != nil {
@@ -392,11 +467,10 @@ func ReadOrCreateLockfile(path string) (*Lockfile, error) {
 			}
 			defer f.Close()
 			defaultLockfileBytes, _ := json.Marshal(EmptyLockfile)
-			written, err := f.Write(defaultLockfileBytes)
+			_, err = f.Write(defaultLockfileBytes)
 			if err != nil {
 				return nil, err
 			}
-			Assert(written == len(defaultLockfileBytes), "must write what is given")
 			return &EmptyLockfile, nil
 		}
 		return nil, err
@@ -425,12 +499,8 @@ func (l *Lockfile) Save(path, indent string) error {
 	if err != nil {
 		return err
 	}
-	written, err := file.Write(toWrite)
-	if err != nil {
-		return err
-	}
-	Assert(written == len(toWrite), "must write what is given")
-	return nil
+	_, err = file.Write(toWrite)
+	return err
 }
 
 func (l *Lockfile) AddConfig(config Config) {
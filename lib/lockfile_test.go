@@ -1,7 +1,11 @@
 package lib
 
 import (
+	"blanktiger/hm/configuration"
 	"blanktiger/hm/instructions"
+	"io"
+	"log/slog"
+	"os"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -308,3 +312,64 @@ func TestUnhideConfigPath(t *testing.T) {
 func TestHideConfigPath(t *testing.T) {
 	assert.Equal(t, pathB, hideConfigPath(pathA))
 }
+
+func TestCreateLockBasedOnConfigsCollectsErrorsOfAllConfigs(t *testing.T) {
+	Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	c := &configuration.Configuration{SourceCfgDir: t.TempDir(), TargetDir: t.TempDir()}
+	for name, install := range map[string]string{"fish": "system:fish\n", "nvim": "sytem:neovim\n", ".zsh": "z\n"} {
+		assert.NoError(t, os.Mkdir(c.SourceCfgDir+"/"+name, 0o755))
+		assert.NoError(t, os.WriteFile(c.SourceCfgDir+"/"+name+INSTALL_PATH_POSTFIX, []byte(install), 0o644))
+	}
+
+	lock, err := CreateLockBasedOnConfigs(c)
+
+	assert.Nil(t, lock)
+	assert.ErrorContains(t, err, "nvim"+INSTALL_PATH_POSTFIX+":1: unknown installation method 'sytem'")
+	assert.ErrorContains(t, err, ".zsh"+INSTALL_PATH_POSTFIX+":1:")
+}
+
+func TestCreateLockBasedOnConfigsTreatsFilesAsConfigs(t *testing.T) {
+	Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	c := &configuration.Configuration{SourceCfgDir: t.TempDir(), TargetDir: t.TempDir()}
+	for _, name := range []string{"starship.toml", "DEPENDENCIES", "POST_DEPLOY", "README.md", ".gitignore"} {
+		assert.NoError(t, os.WriteFile(c.SourceCfgDir+"/"+name, []byte(""), 0o644))
+	}
+
+	lock, err := CreateLockBasedOnConfigs(c)
+
+	assert.NoError(t, err)
+	assert.Len(t, lock.Configs, 1)
+	assert.Equal(t, "starship.toml", lock.Configs[0].Name)
+	assert.Equal(t, c.TargetDir+"/starship.toml", lock.Configs[0].To)
+	assert.Empty(t, lock.HiddenConfigs)
+}
+
+func TestHidingConfigPathTwiceKeepsOneDot(t *testing.T) {
+	assert.Equal(t, pathB, hideConfigPath(pathB))
+	assert.Equal(t, pathA, unhideConfigPath(pathA))
+}
+
+func TestPersistGlobalDepsSelectionKeepsTheRestOfTheFile(t *testing.T) {
+	Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	instructions.Logger = Logger
+	srcDir := t.TempDir()
+	txt := "// tools\n[os=darwin] brew:x\npacman:rg@14\n[os!=darwin] cargo:fd\ncargo:bat\n\nsystem:git curl\n"
+	assert.NoError(t, os.WriteFile(createDepsPath(srcDir), []byte(txt), 0o644))
+	deps, err := ParseGlobalDependencies(srcDir)
+	assert.NoError(t, err)
+	assert.Len(t, deps, 4)
+
+	// NOTE: cargo:bat got deselected
+	lock := Lockfile{GlobalDependencies: []GlobalDependency{deps[0], deps[1], deps[3]}}
+	added := installInstruction{Method: instructions.Brew, Pkg: "jq", Condition: "os!=darwin"}
+	lock.GlobalDependencies = append(lock.GlobalDependencies, newGlobalDependency(&added))
+	err = lock.PersistGlobalDepsSelection(srcDir)
+	assert.NoError(t, err)
+
+	persisted, err := os.ReadFile(createDepsPath(srcDir))
+	assert.NoError(t, err)
+	assert.Equal(t, "// tools\n[os=darwin] brew:x\npacman:rg@14\n[os!=darwin] cargo:fd\n\nsystem:git curl\n[os!=darwin] brew:jq\n", string(persisted))
+	depsAfter, err := ParseGlobalDependencies(srcDir)
+	assert.NoError(t, err)
+	assert.Equal(t, lock.GlobalDependencies, depsAfter)
+}
@@ -0,0 +1,208 @@
+package lib
+
+import (
+	i "blanktiger/hm/instructions"
+	"slices"
+	"strings"
+)
+
+// owner used for packages from config/DEPENDENCIES
+const GLOBAL_DEPS_OWNER = "config/DEPENDENCIES"
+
+// package manager used for `system:` instructions, overridable in tests
+var systemPkgManager = i.SystemPkgManager
+
+// identifies a single package regardless of its options, version pin or
+// condition, `system:` packages are identified by the package manager they
+// resolve to (and the aliased name), so `system:git` and `pacman:git` are the
+// same package on Arch
+func packageKey(inst installInstruction) string {
+	method, pkg := inst.Method, inst.Pkg
+	if manager := systemPkgManager(); method == i.System && manager != i.INVALID {
+		method, pkg = manager, method.ResolvePkg(pkg)
+	}
+	return string(method) + ":" + pkg
+}
+
+// splits instructions with many packages (`system:git curl`) into one
+// instruction per package, bash instructions are never split
+func splitPackages(inst installInstruction) []installInstruction {
+	if inst.Method == i.Bash || !strings.Contains(inst.Pkg, " ") {
+		return []installInstruction{inst}
+	}
+
+	res := []installInstruction{}
+	for pkg := range strings.FieldsSeq(inst.Pkg) {
+		single := inst
+		single.Pkg = pkg
+		res = append(res, single)
+	}
+	return res
+}
+
+func addOwner(owners map[string][]string, inst installInstruction, owner string) {
+	for _, single := range splitPackages(inst) {
+		key := packageKey(single)
+		if !slices.Contains(owners[key], owner) {
+			owners[key] = append(owners[key], owner)
+		}
+	}
+}
+
+// UpdateDependencyOwners records which active configs and global
+// dependencies require each package, hidden configs don't own anything
+func (l *Lockfile) UpdateDependencyOwners() {
+	owners := map[string][]string{}
+	for _, dep := range l.GlobalDependencies {
+		addOwner(owners, *dep.Instruction, GLOBAL_DEPS_OWNER)
+	}
+
+	for _, cfg := range l.Configs {
+		if cfg.Requirements.Install != nil {
+			addOwner(owners, *cfg.Requirements.Install, cfg.Name)
+		}
+		for _, dep := range cfg.Requirements.Dependencies {
+			addOwner(owners, dep, cfg.Name)
+		}
+	}
+
+	l.DependencyOwners = owners
+}
+
+type uninstallAction string
+
+const (
+	removePkg uninstallAction = "remove"
+	keepPkg   uninstallAction = "keep"
+)
+
+// single entry of the uninstallation plan of a hidden config
+type uninstallPlanEntry struct {
+	Instruction installInstruction
+	Action      uninstallAction
+	Reason      string
+}
+
+// planDependenciesUninstall decides which dependencies of a hidden config can
+// be removed, a dependency is kept as long as any active config or global
+// dependency still requires it
+func planDependenciesUninstall(cfg Config, owners map[string][]string) []uninstallPlanEntry {
+	plan := []uninstallPlanEntry{}
+	for _, dep := range cfg.Requirements.Dependencies {
+		for _, single := range splitPackages(dep) {
+			entry := uninstallPlanEntry{Instruction: single}
+			if requiredBy := owners[packageKey(single)]; len(requiredBy) > 0 {
+				entry.Action = keepPkg
+				entry.Reason = "still required by " + strings.Join(requiredBy, ", ")
+			} else if single.Method == i.Bash {
+				entry.Action = keepPkg
+				entry.Reason = "bash dependencies can't be uninstalled automatically"
+			} else {
+				entry.Action = removePkg
+				entry.Reason = "no active config or global dependency requires it"
+			}
+			plan = append(plan, entry)
+		}
+	}
+	return plan
+}
+
+// planPkgUninstall decides which packages of a hidden config's install
+// instruction can be removed, a package is kept as long as any active config
+// or global dependency still requires it
+func planPkgUninstall(inst installInstruction, owners map[string][]string) []uninstallPlanEntry {
+	plan := []uninstallPlanEntry{}
+	for _, single := range splitPackages(inst) {
+		entry := uninstallPlanEntry{Instruction: single, Action: removePkg, Reason: "no active config or global dependency requires it"}
+		if requiredBy := owners[packageKey(single)]; len(requiredBy) > 0 {
+			entry.Action = keepPkg
+			entry.Reason = "still required by " + strings.Join(requiredBy, ", ")
+		}
+		plan = append(plan, entry)
+	}
+	return plan
+}
+
+// withoutKeptPkgs narrows the install instruction (and the names resolved
+// during its installation) to the packages the plan removes, nil if the plan
+// keeps all of them
+func withoutKeptPkgs(inst installInstruction, prevInfo installInfo, plan []uninstallPlanEntry) (*installInstruction, installInfo) {
+	resolved := strings.Fields(prevInfo.ResolvedPkg)
+	pkgs, resolvedPkgs := []string{}, []string{}
+	for idx, entry := range plan {
+		if entry.Action != removePkg {
+			continue
+		}
+		pkgs = append(pkgs, entry.Instruction.Pkg)
+		if len(resolved) == len(plan) {
+			resolvedPkgs = append(resolvedPkgs, resolved[idx])
+		}
+	}
+
+	switch len(pkgs) {
+	case 0:
+		return nil, prevInfo
+	case len(plan):
+		return &inst, prevInfo
+	}
+	inst.Pkg = strings.Join(pkgs, " ")
+	// NOTE: when the names can't be matched with the packages anymore they are
+	// resolved again
+	prevInfo.ResolvedPkg = strings.Join(resolvedPkgs, " ")
+	return &inst, prevInfo
+}
+
+// single global dependency package removed from config/DEPENDENCIES since the
+// previous run
+type removedGlobalDep struct {
+	uninstallPlanEntry
+	// install info of the whole line the package came from
+	Dep GlobalDependency
+	// whether the line contained only this package
+	Whole bool
+}
+
+// planRemovedGlobalDependencies finds installed global dependencies that are
+// not in config/DEPENDENCIES anymore, they are removed unless some active
+// config still requires them, packages kept or failed to be removed before
+// (still installed in RemovedGlobalDependencies) are planned again
+func planRemovedGlobalDependencies(before, after *Lockfile) []removedGlobalDep {
+	current := map[string]bool{}
+	for _, dep := range after.GlobalDependencies {
+		for _, single := range splitPackages(*dep.Instruction) {
+			current[packageKey(single)] = true
+		}
+	}
+
+	plan := []removedGlobalDep{}
+	planned := map[string]bool{}
+	for _, dep := range slices.Concat(before.GlobalDependencies, before.RemovedGlobalDependencies) {
+		if !dep.InstallInfo.IsInstalled {
+			continue
+		}
+
+		singles := splitPackages(*dep.Instruction)
+		for _, single := range singles {
+			key := packageKey(single)
+			if current[key] || planned[key] {
+				continue
+			}
+			planned[key] = true
+
+			entry := removedGlobalDep{Dep: dep, Whole: len(singles) == 1}
+			entry.Instruction = single
+			if requiredBy := after.DependencyOwners[key]; len(requiredBy) > 0 {
+				entry.Action = keepPkg
+				entry.Reason = "removed from " + GLOBAL_DEPS_OWNER + ", but still required by " + strings.Join(requiredBy, ", ")
+			} else if single.Method == i.Bash {
+				entry.Action = keepPkg
+				entry.Reason = "bash dependencies can't be uninstalled automatically"
+			} else {
+				entry.Action = removePkg
+				entry.Reason = "removed from " + GLOBAL_DEPS_OWNER + " and no active config requires it"
+			}
+			plan = append(plan, entry)
+		}
+	}
+	return plan
+}
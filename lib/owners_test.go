@@ -0,0 +1,173 @@
+package lib
+
+import (
+	"blanktiger/hm/instructions"
+	"io"
+	"log/slog"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateDependencyOwners(t *testing.T) {
+	fish := createCfg("fish")
+	fish.Requirements.Install = &installInstruction{Method: instructions.System, Pkg: "fish"}
+	fish.Requirements.Dependencies = []installInstruction{{Method: instructions.System, Pkg: "fzf git"}}
+	lazygit := createCfg("lazygit")
+	lazygit.Requirements.Dependencies = []installInstruction{{Method: instructions.System, Pkg: "git"}}
+	lock := Lockfile{
+		GlobalDependencies: []GlobalDependency{commonGlobalDependency},
+		Configs:            []Config{fish, lazygit},
+		HiddenConfigs:      []Config{createCfg("zsh")},
+	}
+
+	lock.UpdateDependencyOwners()
+
+	expected := map[string][]string{
+		"system:fish": {GLOBAL_DEPS_OWNER, "fish"},
+		"system:fzf":  {"fish"},
+		"system:git":  {"fish", "lazygit"},
+	}
+	assert.Equal(t, expected, lock.DependencyOwners)
+}
+
+func TestSystemAndItsPackageManagerOwnTheSamePackage(t *testing.T) {
+	prev := systemPkgManager
+	defer func() { systemPkgManager = prev }()
+	systemPkgManager = func() instructions.InstallMethod { return instructions.Pacman }
+	lazygit := createCfg("lazygit")
+	lazygit.Requirements.Dependencies = []installInstruction{{Method: instructions.System, Pkg: "git"}}
+	lock := Lockfile{Configs: []Config{lazygit}}
+	lock.UpdateDependencyOwners()
+	hidden := createCfg("tmux")
+	hidden.Requirements.Dependencies = []installInstruction{{Method: instructions.Pacman, Pkg: "git"}}
+
+	plan := planDependenciesUninstall(hidden, lock.DependencyOwners)
+
+	assert.Equal(t, map[string][]string{"pacman:git": {"lazygit"}}, lock.DependencyOwners)
+	assert.Len(t, plan, 1)
+	assert.Equal(t, keepPkg, plan[0].Action)
+	assert.Equal(t, "still required by lazygit", plan[0].Reason)
+}
+
+func TestPlanDependenciesUninstall(t *testing.T) {
+	hidden := createCfg("tmux")
+	hidden.Requirements.Dependencies = []installInstruction{
+		{Method: instructions.System, Pkg: "git xclip"},
+		{Method: instructions.Bash, Pkg: "curl -fsSL https://example.com | sh"},
+	}
+	owners := map[string][]string{"system:git": {"lazygit"}}
+
+	plan := planDependenciesUninstall(hidden, owners)
+
+	assert.Len(t, plan, 3)
+	assert.Equal(t, "git", plan[0].Instruction.Pkg)
+	assert.Equal(t, keepPkg, plan[0].Action)
+	assert.Equal(t, "still required by lazygit", plan[0].Reason)
+	assert.Equal(t, "xclip", plan[1].Instruction.Pkg)
+	assert.Equal(t, removePkg, plan[1].Action)
+	assert.Equal(t, keepPkg, plan[2].Action)
+}
+
+func TestUninstallKeepsPackagesOfInstallLineSharedWithAnotherConfig(t *testing.T) {
+	lazygit := createCfg("lazygit")
+	lazygit.Requirements.Dependencies = []installInstruction{{Method: instructions.System, Pkg: "git"}}
+	lock := Lockfile{Configs: []Config{lazygit}}
+	lock.UpdateDependencyOwners()
+	inst := installInstruction{Method: instructions.System, Pkg: "git curl"}
+	prevInfo := installInfo{ResolvedPkg: "git curl", IsInstalled: true}
+
+	plan := planPkgUninstall(inst, lock.DependencyOwners)
+	remove, removeInfo := withoutKeptPkgs(inst, prevInfo, plan)
+
+	assert.Len(t, plan, 2)
+	assert.Equal(t, keepPkg, plan[0].Action)
+	assert.Equal(t, "still required by lazygit", plan[0].Reason)
+	assert.Equal(t, removePkg, plan[1].Action)
+	assert.Equal(t, "curl", remove.Pkg)
+	assert.Equal(t, "curl", removeInfo.ResolvedPkg)
+
+	lock.Configs = append(lock.Configs, createCfg("tmux"))
+	lock.Configs[1].Requirements.Dependencies = []installInstruction{{Method: instructions.System, Pkg: "curl"}}
+	lock.UpdateDependencyOwners()
+	remove, _ = withoutKeptPkgs(inst, prevInfo, planPkgUninstall(inst, lock.DependencyOwners))
+	assert.Nil(t, remove)
+}
+
+func TestPlanRemovedGlobalDependencies(t *testing.T) {
+	installed := installInfo{IsInstalled: true}
+	before := Lockfile{
+		GlobalDependencies: []GlobalDependency{
+			{Instruction: &installInstruction{Method: instructions.System, Pkg: "git curl wget"}, InstallInfo: installed},
+			{Instruction: &installInstruction{Method: instructions.Cargo, Pkg: "bat"}, InstallInfo: installed},
+		},
+	}
+	after := Lockfile{
+		GlobalDependencies: []GlobalDependency{
+			{Instruction: &installInstruction{Method: instructions.System, Pkg: "git"}},
+		},
+		DependencyOwners: map[string][]string{"system:wget": {"fish"}},
+	}
+
+	plan := planRemovedGlobalDependencies(&before, &after)
+
+	assert.Len(t, plan, 3)
+	assert.Equal(t, "curl", plan[0].Instruction.Pkg)
+	assert.Equal(t, removePkg, plan[0].Action)
+	assert.False(t, plan[0].Whole)
+	assert.Equal(t, "wget", plan[1].Instruction.Pkg)
+	assert.Equal(t, keepPkg, plan[1].Action)
+	assert.Equal(t, "bat", plan[2].Instruction.Pkg)
+	assert.Equal(t, removePkg, plan[2].Action)
+	assert.True(t, plan[2].Whole)
+}
+
+func TestFailedRemovalsOfGlobalDependenciesAreRetried(t *testing.T) {
+	Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	// NOTE: releases without recorded files can't be uninstalled
+	broken := installInstruction{Method: instructions.Release, Pkg: "tool"}
+	before := Lockfile{
+		GlobalDependencies: []GlobalDependency{{Instruction: &broken, InstallInfo: installInfo{IsInstalled: true}}},
+	}
+	after := Lockfile{GlobalDependencies: []GlobalDependency{}, RemovedGlobalDependencies: []GlobalDependency{}}
+	report := NewReport("uninstall")
+
+	UninstallRemovedGlobalDependencies(t.Context(), &before, &after, report)
+
+	assert.Equal(t, PartialFailureError{Failed: 1}, report.Err())
+	assert.Len(t, after.RemovedGlobalDependencies, 1)
+	assert.True(t, after.RemovedGlobalDependencies[0].InstallInfo.IsInstalled)
+
+	next := Lockfile{GlobalDependencies: []GlobalDependency{}, RemovedGlobalDependencies: slices.Clone(after.RemovedGlobalDependencies)}
+	plan := planRemovedGlobalDependencies(&after, &next)
+	assert.Len(t, plan, 1)
+	assert.Equal(t, removePkg, plan[0].Action)
+	assert.Equal(t, "tool", plan[0].Instruction.Pkg)
+
+	UninstallRemovedGlobalDependencies(t.Context(), &after, &next, NewReport("uninstall"))
+	assert.Len(t, next.RemovedGlobalDependencies, 1, "the entry is replaced, not duplicated")
+}
+
+func TestKeptGlobalDependenciesAreRemovedWithTheirLastConfig(t *testing.T) {
+	Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	bat := installInstruction{Method: instructions.Cargo, Pkg: "bat"}
+	fzf := createCfg("fzf")
+	fzf.Requirements.Dependencies = []installInstruction{bat}
+	before := Lockfile{
+		GlobalDependencies: []GlobalDependency{{Instruction: &bat, InstallInfo: installInfo{IsInstalled: true}}},
+	}
+	after := Lockfile{GlobalDependencies: []GlobalDependency{}, Configs: []Config{fzf}}
+
+	UninstallRemovedGlobalDependencies(t.Context(), &before, &after, NewReport("uninstall"))
+
+	assert.Len(t, after.RemovedGlobalDependencies, 1)
+	assert.True(t, after.RemovedGlobalDependencies[0].InstallInfo.IsInstalled)
+
+	next := Lockfile{GlobalDependencies: []GlobalDependency{}, HiddenConfigs: []Config{fzf}}
+	next.UpdateDependencyOwners()
+	plan := planRemovedGlobalDependencies(&after, &next)
+	assert.Len(t, plan, 1)
+	assert.Equal(t, "bat", plan[0].Instruction.Pkg)
+	assert.Equal(t, removePkg, plan[0].Action)
+}
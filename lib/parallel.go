@@ -0,0 +1,108 @@
+package lib
+
+import (
+	i "blanktiger/hm/instructions"
+	"bytes"
+	"context"
+	"io"
+	"sync"
+)
+
+// Jobs is the number of configs installed or upgraded at the same time
+var Jobs = 1
+
+// runs f for every config, at most Jobs of them at the same time, configs
+// that didn't start before ctx is done are skipped
+func forEachConfig(ctx context.Context, configs []Config, f func(cfg Config)) {
+	sem := make(chan struct{}, max(Jobs, 1))
+	wg := sync.WaitGroup{}
+	for _, cfg := range configs {
+		sem <- struct{}{}
+		if ctx.Err() != nil {
+			<-sem
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			f(cfg)
+		}()
+	}
+	wg.Wait()
+}
+
+var (
+	pkgManagerLocksMu = sync.Mutex{}
+	// keyed by InstallMethod.LockGroup
+	pkgManagerLocks = map[string]*sync.Mutex{}
+)
+
+// lockPkgManager makes sure that only one command of a package manager runs at
+// a time (most of them hold a lock on their database anyway), the returned
+// function releases the lock
+func lockPkgManager(method i.InstallMethod) (unlock func()) {
+	group := method.LockGroup()
+	if group == "" {
+		return func() {}
+	}
+
+	pkgManagerLocksMu.Lock()
+	mu, ok := pkgManagerLocks[group]
+	if !ok {
+		mu = &sync.Mutex{}
+		pkgManagerLocks[group] = mu
+	}
+	pkgManagerLocksMu.Unlock()
+
+	mu.Lock()
+	return mu.Unlock
+}
+
+// outputMu keeps lines of commands running at the same time from mixing
+var outputMu = sync.Mutex{}
+
+// prefixWriter writes whole lines prefixed with the owner of the command, so
+// that output of commands running at the same time can be told apart
+type prefixWriter struct {
+	prefix string
+	w      io.Writer
+	buf    []byte
+}
+
+func newPrefixWriter(owner string, w io.Writer) *prefixWriter {
+	return &prefixWriter{prefix: "[" + owner + "] ", w: w}
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+	for {
+		idx := bytes.IndexByte(p.buf, '\n')
+		if idx < 0 {
+			break
+		}
+		err := p.writeLine(p.buf[:idx+1])
+		p.buf = p.buf[idx+1:]
+		if err != nil {
+			return len(b), err
+		}
+	}
+	return len(b), nil
+}
+
+// flush writes the last line even if it doesn't end with a newline
+func (p *prefixWriter) flush() error {
+	if len(p.buf) == 0 {
+		return nil
+	}
+	err := p.writeLine(append(p.buf, '\n'))
+	p.buf = nil
+	return err
+}
+
+func (p *prefixWriter) writeLine(line []byte) error {
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	_, err := p.w.Write(append([]byte(p.prefix), line...))
+	return err
+}
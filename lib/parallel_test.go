@@ -0,0 +1,104 @@
+package lib
+
+import (
+	"blanktiger/hm/instructions"
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstallInParallel(t *testing.T) {
+	Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	instructions.Logger = Logger
+	prevJobs, prevStdout := Jobs, Stdout
+	defer func() { Jobs, Stdout = prevJobs, prevStdout }()
+	Jobs = 3
+	Stdout = io.Discard
+
+	configs := []Config{}
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		cfg := createCfg(name)
+		cfg.Requirements.Install = &installInstruction{Method: instructions.Bash, Pkg: "true"}
+		configs = append(configs, cfg)
+	}
+	configs[2].Requirements.Install.Pkg = "false"
+	lock := Lockfile{Configs: configs}
+	report := NewReport("install")
+
+	forUpdate := Install(t.Context(), &lock, lock.Configs, report)
+
+	assert.Len(t, forUpdate, 4)
+	assert.NotContains(t, forUpdate, "c")
+	assert.Len(t, report.Entries, 5)
+	assert.Equal(t, PartialFailureError{Failed: 1}, report.Err())
+	assert.Len(t, lock.Ledger, 4)
+}
+
+func TestInstallStopsWhenCancelled(t *testing.T) {
+	Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	instructions.Logger = Logger
+	prevStdout := Stdout
+	defer func() { Stdout = prevStdout }()
+	Stdout = io.Discard
+
+	configs := []Config{}
+	for _, pkg := range []string{"true", "sleep 5", "true"} {
+		cfg := createCfg(pkg)
+		cfg.Requirements.Install = &installInstruction{Method: instructions.Bash, Pkg: pkg}
+		configs = append(configs, cfg)
+	}
+	configs[2].Name = "never"
+	lock := Lockfile{Configs: configs}
+	report := NewReport("install")
+	ctx, cancel := context.WithCancel(t.Context())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	forUpdate := Install(ctx, &lock, lock.Configs, report)
+
+	// NOTE: only what finished is returned, so that the lockfile saved after
+	// the interruption is accurate
+	assert.Equal(t, []string{"true"}, slices.Collect(maps.Keys(forUpdate)))
+	assert.Len(t, report.Entries, 2)
+	assert.Len(t, lock.Ledger, 1)
+}
+
+func TestPrefixWriter(t *testing.T) {
+	out := bytes.Buffer{}
+	w := newPrefixWriter("fish", &out)
+
+	_, err := w.Write([]byte("first\nsec"))
+	assert.NoError(t, err)
+	assert.Equal(t, "[fish] first\n", out.String())
+
+	_, err = w.Write([]byte("ond\nlast"))
+	assert.NoError(t, err)
+	assert.NoError(t, w.flush())
+	assert.Equal(t, "[fish] first\n[fish] second\n[fish] last\n", out.String())
+}
+
+func TestRunPrefixesOutputWithJobs(t *testing.T) {
+	Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	prevJobs, prevStdout, prevLog := Jobs, Stdout, CmdLog
+	defer func() { Jobs, Stdout, CmdLog = prevJobs, prevStdout, prevLog }()
+	Jobs = 2
+	out := bytes.Buffer{}
+	Stdout = &out
+	CmdLog = NewRunLog(t.TempDir())
+
+	err := execute(t.Context(), "nvim", "echo hello")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "[nvim] hello\n", out.String())
+	// NOTE: the log belongs to a single config, so it isn't prefixed
+	log, err := os.ReadFile(CmdLog.Dir() + "/" + LogFileName("nvim"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(log), "output:\nhello\n==> exit code: 0")
+}
@@ -0,0 +1,240 @@
+package lib
+
+import (
+	"archive/tar"
+	"archive/zip"
+	i "blanktiger/hm/instructions"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// release instructions look like this:
+//
+//	release(url=https://example.com/{version}/tool-{os}-{arch}.tar.gz,sha256=...,version=1.0.0,bin=tool):tool
+//
+// `bin` defaults to the package name and `dir` (where the binary is put)
+// defaults to ~/.local/bin, `sha256_<os>_<arch>` (e.g. sha256_linux_x86_64)
+// takes precedence over `sha256` on that platform
+const (
+	releaseUrlOpt     = "url"
+	releaseSha256Opt  = "sha256"
+	releaseVersionOpt = "version"
+	releaseBinOpt     = "bin"
+	releaseDirOpt     = "dir"
+)
+
+var (
+	releaseMissingUrlErr    = errors.New("release instruction is missing the url option")
+	releaseMissingSha256Err = errors.New("release instruction is missing the sha256 option (or sha256_<os>_<arch> for this platform)")
+)
+
+func expandReleaseUrl(template, version string) string {
+	replacer := strings.NewReplacer(
+		"{os}", runtime.GOOS,
+		// naming used by most projects publishing release archives
+		"{arch}", i.Arch(),
+		"{version}", version,
+	)
+	return replacer.Replace(template)
+}
+
+// the version option takes precedence over an exact pin (`release(...):tool==1.0`)
+func releaseVersion(inst installInstruction) string {
+	if version := inst.Options[releaseVersionOpt]; version != "" {
+		return version
+	}
+	if !inst.Version.IsEmpty() && inst.Version.Op == "==" {
+		return inst.Version.Version
+	}
+	return ""
+}
+
+// the checksum of the archive for this platform, falls back to the sha256
+// option shared by all of them
+func releaseSha256(inst installInstruction) string {
+	platformOpt := releaseSha256Opt + "_" + runtime.GOOS + "_" + i.Arch()
+	if sum := inst.Options[platformOpt]; sum != "" {
+		return strings.ToLower(sum)
+	}
+	return strings.ToLower(inst.Options[releaseSha256Opt])
+}
+
+// bin is put into the directory of binaries, so it has to be a plain file name
+func checkReleaseBin(bin string) error {
+	if bin == "." || bin == ".." || strings.ContainsAny(bin, `/\`) {
+		return fmt.Errorf("bin '%s' must be a file name, not a path", bin)
+	}
+	return nil
+}
+
+func releaseBinDir(inst installInstruction) (string, error) {
+	if dir := inst.Options[releaseDirOpt]; dir != "" {
+		return dir, nil
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".local", "bin"), nil
+}
+
+func installRelease(ctx context.Context, inst installInstruction) (res installResult, err error) {
+	template := inst.Options[releaseUrlOpt]
+	if template == "" {
+		return res, releaseMissingUrlErr
+	}
+	expectedSum := releaseSha256(inst)
+	if expectedSum == "" {
+		return res, releaseMissingSha256Err
+	}
+	bin := inst.Options[releaseBinOpt]
+	if bin == "" {
+		bin = inst.Pkg
+	}
+	err = checkReleaseBin(bin)
+	if err != nil {
+		return res, err
+	}
+	dir, err := releaseBinDir(inst)
+	if err != nil {
+		return res, err
+	}
+
+	url := expandReleaseUrl(template, releaseVersion(inst))
+	res.Cmd = "download " + url
+	Logger.Info("downloading release", "pkg", inst.Pkg, "url", url)
+
+	data, err := download(ctx, url)
+	if err != nil {
+		return res, err
+	}
+
+	sum := sha256.Sum256(data)
+	gotSum := hex.EncodeToString(sum[:])
+	if gotSum != expectedSum {
+		return res, fmt.Errorf("checksum mismatch for '%s', expected sha256 '%s', got '%s'", url, expectedSum, gotSum)
+	}
+
+	binData, err := extractReleaseBinary(url, data, bin)
+	if err != nil {
+		return res, err
+	}
+
+	err = os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return res, err
+	}
+	to := filepath.Join(dir, bin)
+	err = os.WriteFile(to, binData, 0o755)
+	if err != nil {
+		return res, err
+	}
+
+	Logger.Info("Successfully installed", "pkg", inst.Pkg, "path", to)
+	res.Files = []string{to}
+	res.Version = releaseVersion(inst)
+	return res, nil
+}
+
+func uninstallRelease(files []string) (cmd string, err error) {
+	cmd = "rm " + strings.Join(files, " ")
+	if len(files) == 0 {
+		return cmd, errors.New("there are no recorded files for this release, don't know what to remove")
+	}
+
+	for _, file := range files {
+		Logger.Info("removing installed release file", "path", file)
+		err = os.Remove(file)
+		if err != nil && !os.IsNotExist(err) {
+			return cmd, err
+		}
+	}
+	return cmd, nil
+}
+
+func download(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("couldn't download '%s', status: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// archive type is inferred from the url, anything that is not a known archive
+// is treated as the binary itself
+func extractReleaseBinary(url string, data []byte, bin string) ([]byte, error) {
+	switch {
+	case strings.HasSuffix(url, ".tar.gz"), strings.HasSuffix(url, ".tgz"):
+		return extractFromTarGz(data, bin)
+	case strings.HasSuffix(url, ".zip"):
+		return extractFromZip(data, bin)
+	default:
+		return data, nil
+	}
+}
+
+func extractFromTarGz(data []byte, bin string) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg || filepath.Base(header.Name) != bin {
+			continue
+		}
+		return io.ReadAll(tr)
+	}
+
+	return nil, fmt.Errorf("couldn't find binary '%s' in the release archive", bin)
+}
+
+func extractFromZip(data []byte, bin string) ([]byte, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || filepath.Base(f.Name) != bin {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return io.ReadAll(rc)
+	}
+
+	return nil, fmt.Errorf("couldn't find binary '%s' in the release archive", bin)
+}
@@ -0,0 +1,178 @@
+package lib
+
+import (
+	"archive/tar"
+	"blanktiger/hm/instructions"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var releaseBinContent = []byte("#!/bin/sh\necho hello\n")
+
+func createTarGz(t *testing.T, files map[string][]byte) []byte {
+	buf := bytes.Buffer{}
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o755, Size: int64(len(content)), Typeflag: tar.TypeReg})
+		assert.NoError(t, err)
+		_, err = tw.Write(content)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, tw.Close())
+	assert.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func serveRelease(t *testing.T, path string, data []byte) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestInstallAndUninstallRelease(t *testing.T) {
+	Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	archive := createTarGz(t, map[string][]byte{
+		"tool-1.2.3/README.md": []byte("readme"),
+		"tool-1.2.3/tool":      releaseBinContent,
+	})
+	path := "/1.2.3/tool-" + runtime.GOOS + "-" + instructions.Arch() + ".tar.gz"
+	server := serveRelease(t, path, archive)
+	dir := t.TempDir()
+
+	inst := installInstruction{
+		Method: instructions.Release,
+		Pkg:    "tool",
+		Options: instructions.Options{
+			"url":     server.URL + "/{version}/tool-{os}-{arch}.tar.gz",
+			"sha256":  sha256Hex(archive),
+			"version": "1.2.3",
+			"dir":     dir,
+		},
+	}
+
+	res, err := install(t.Context(), "tool", inst)
+	assert.NoError(t, err)
+	binPath := filepath.Join(dir, "tool")
+	assert.Equal(t, []string{binPath}, res.Files)
+	installed, err := os.ReadFile(binPath)
+	assert.NoError(t, err)
+	assert.Equal(t, releaseBinContent, installed)
+
+	_, err = uninstall(t.Context(), "tool", &inst, installInfo{InstalledFiles: res.Files})
+	assert.NoError(t, err)
+	_, err = os.Stat(binPath)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestInstallReleaseChecksumMismatch(t *testing.T) {
+	Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	archive := createTarGz(t, map[string][]byte{"tool": releaseBinContent})
+	server := serveRelease(t, "/tool.tar.gz", archive)
+	dir := t.TempDir()
+
+	inst := installInstruction{
+		Method: instructions.Release,
+		Pkg:    "tool",
+		Options: instructions.Options{
+			"url":     server.URL + "/tool.tar.gz",
+			"sha256":  sha256Hex([]byte("something else")),
+			"dir":     dir,
+			"retries": "0",
+		},
+	}
+
+	_, err := install(t.Context(), "tool", inst)
+	assert.ErrorContains(t, err, "checksum mismatch")
+	_, err = os.Stat(filepath.Join(dir, "tool"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestInstallReleaseMissingBinary(t *testing.T) {
+	Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	archive := createTarGz(t, map[string][]byte{"other": releaseBinContent})
+	server := serveRelease(t, "/tool.tar.gz", archive)
+
+	inst := installInstruction{
+		Method: instructions.Release,
+		Pkg:    "tool",
+		Options: instructions.Options{
+			"url":     server.URL + "/tool.tar.gz",
+			"sha256":  sha256Hex(archive),
+			"dir":     t.TempDir(),
+			"retries": "0",
+		},
+	}
+
+	_, err := install(t.Context(), "tool", inst)
+	assert.ErrorContains(t, err, "couldn't find binary 'tool'")
+}
+
+func TestInstallReleasePlatformChecksum(t *testing.T) {
+	Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	server := serveRelease(t, "/tool", releaseBinContent)
+	dir := t.TempDir()
+
+	inst := installInstruction{
+		Method: instructions.Release,
+		Pkg:    "tool",
+		Options: instructions.Options{
+			"url": server.URL + "/tool",
+			"sha256_" + runtime.GOOS + "_" + instructions.Arch(): sha256Hex(releaseBinContent),
+			"sha256":  sha256Hex([]byte("archive of another platform")),
+			"dir":     dir,
+			"retries": "0",
+		},
+	}
+
+	_, err := install(t.Context(), "tool", inst)
+	assert.NoError(t, err)
+	assert.FileExists(t, filepath.Join(dir, "tool"))
+}
+
+func TestInstallReleaseRejectsBinPaths(t *testing.T) {
+	Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	server := serveRelease(t, "/tool", releaseBinContent)
+	dir := t.TempDir()
+
+	for _, bin := range []string{"../tool", "sub/tool", ".."} {
+		inst := installInstruction{
+			Method: instructions.Release,
+			Pkg:    "tool",
+			Options: instructions.Options{
+				"url":     server.URL + "/tool",
+				"sha256":  sha256Hex(releaseBinContent),
+				"bin":     bin,
+				"dir":     dir + "/bin",
+				"retries": "0",
+			},
+		}
+
+		_, err := install(t.Context(), "tool", inst)
+		assert.ErrorContains(t, err, "must be a file name", bin)
+	}
+	assert.NoFileExists(t, dir+"/tool")
+}
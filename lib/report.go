@@ -0,0 +1,128 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"slices"
+	"sync"
+	"time"
+)
+
+type Action string
+
+const (
+	ActionSymlink   Action = "symlink"
+	ActionCopy      Action = "copy"
+	ActionRemove    Action = "remove"
+	ActionInstall   Action = "install"
+	ActionUpgrade   Action = "upgrade"
+	ActionUninstall Action = "uninstall"
+	ActionHook      Action = "hook"
+	ActionUnit      Action = "unit"
+)
+
+// ReportEntry is a single thing done during a run, e.g. symlinking a config or
+// installing one of its dependencies
+type ReportEntry struct {
+	// config name or GLOBAL_DEPS_OWNER
+	Owner  string `json:"owner"`
+	Action Action `json:"action"`
+	// instruction for packages, target path for configs
+	Subject string `json:"subject"`
+	// empty if nothing was run, e.g. for symlinks
+	Cmd string `json:"cmd,omitempty"`
+	// -1 if the command couldn't be run at all
+	ExitCode   int    `json:"exitCode"`
+	DurationMs int64  `json:"durationMs"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Report describes what was done during a run and what failed, it's printed
+// with `--output json`
+type Report struct {
+	Command      string        `json:"command"`
+	RunId        string        `json:"runId,omitempty"`
+	SourceCommit string        `json:"sourceCommit,omitempty"`
+	Entries      []ReportEntry `json:"entries"`
+	Failed       int           `json:"failed"`
+	// set when the whole run was stopped, not just a single action
+	Error string `json:"error,omitempty"`
+
+	// configs can be installed in parallel (see Jobs)
+	mu sync.Mutex
+}
+
+func NewReport(command string) *Report {
+	return &Report{Command: command, Entries: []ReportEntry{}}
+}
+
+// add records an action that started at `start` and ended just now
+func (r *Report) add(owner string, action Action, subject, cmd string, start time.Time, err error) {
+	entry := ReportEntry{
+		Owner:      owner,
+		Action:     action,
+		Subject:    subject,
+		Cmd:        cmd,
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		r.Failed++
+		entry.Error = err.Error()
+		entry.ExitCode = -1
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			entry.ExitCode = exitErr.ExitCode()
+		}
+	}
+	r.Entries = append(r.Entries, entry)
+}
+
+// Failures are the packages that couldn't be installed or upgraded, they're
+// kept in the lockfile for `hm install --failed`
+func (r *Report) Failures() []ReportEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	res := []ReportEntry{}
+	for _, entry := range r.Entries {
+		if entry.Error != "" && (entry.Action == ActionInstall || entry.Action == ActionUpgrade) {
+			res = append(res, entry)
+		}
+	}
+	return res
+}
+
+// FailedConfigs picks the configs which packages failed to install (or upgrade,
+// based on the action) during the run that saved the lockfile
+func (l *Lockfile) FailedConfigs(configs []Config, action Action) []Config {
+	res := []Config{}
+	for _, cfg := range configs {
+		failed := slices.ContainsFunc(l.Failures, func(e ReportEntry) bool {
+			return e.Owner == cfg.Name && e.Action == action
+		})
+		if failed {
+			res = append(res, cfg)
+		}
+	}
+	return res
+}
+
+// PartialFailureError means that the run went through, but some of the
+// actions failed
+type PartialFailureError struct {
+	Failed int
+}
+
+func (e PartialFailureError) Error() string {
+	return fmt.Sprintf("%d action(s) failed, see the log above", e.Failed)
+}
+
+// Err returns PartialFailureError if any of the actions failed
+func (r *Report) Err() error {
+	if r.Failed > 0 {
+		return PartialFailureError{Failed: r.Failed}
+	}
+	return nil
+}
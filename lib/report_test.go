@@ -0,0 +1,84 @@
+package lib
+
+import (
+	"blanktiger/hm/instructions"
+	"errors"
+	"io"
+	"log/slog"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstallReportsFailuresAndContinues(t *testing.T) {
+	Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	instructions.Logger = Logger
+	broken := createCfg("broken")
+	broken.Requirements.Install = &installInstruction{Method: instructions.Bash, Pkg: "false"}
+	fine := createCfg("fine")
+	fine.Requirements.Install = &installInstruction{Method: instructions.Bash, Pkg: "true"}
+	lock := Lockfile{Configs: []Config{broken, fine}}
+	report := NewReport("install")
+
+	forUpdate := Install(t.Context(), &lock, lock.Configs, report)
+
+	assert.NotContains(t, forUpdate, "broken")
+	assert.True(t, forUpdate["fine"].IsInstalled)
+	assert.Len(t, report.Entries, 2)
+	assert.Equal(t, ReportEntry{Owner: "broken", Action: ActionInstall, Subject: "bash:false", Cmd: "false", ExitCode: 1, Error: "exit status 1"}, withoutDuration(report.Entries[0]))
+	assert.Equal(t, ReportEntry{Owner: "fine", Action: ActionInstall, Subject: "bash:true", Cmd: "true"}, withoutDuration(report.Entries[1]))
+	assert.Equal(t, PartialFailureError{Failed: 1}, report.Err())
+}
+
+func TestReportWithoutFailures(t *testing.T) {
+	report := NewReport("apply")
+
+	assert.NoError(t, report.Err())
+	assert.Empty(t, report.Entries)
+}
+
+func withoutDuration(entry ReportEntry) ReportEntry {
+	entry.DurationMs = 0
+	return entry
+}
+
+func TestFailuresArePickedForRetrying(t *testing.T) {
+	report := NewReport("install")
+	report.add("fish", ActionSymlink, "/home/me/.config/fish", "", time.Now(), errors.New("permission denied"))
+	report.add("nvim", ActionInstall, "system:neovim", "sudo pacman -S --noconfirm neovim", time.Now(), errors.New("exit status 1"))
+	report.add("tmux", ActionInstall, "system:tmux", "sudo pacman -S --noconfirm tmux", time.Now(), nil)
+	report.add("rg", ActionUpgrade, "cargo:ripgrep", "cargo install ripgrep", time.Now(), errors.New("exit status 101"))
+
+	failures := report.Failures()
+	assert.Len(t, failures, 2)
+	assert.Equal(t, "nvim", failures[0].Owner)
+
+	lock := Lockfile{Failures: failures}
+	configs := []Config{createCfg("fish"), createCfg("nvim"), createCfg("tmux"), createCfg("rg")}
+	assert.Equal(t, []Config{createCfg("nvim")}, lock.FailedConfigs(configs, ActionInstall))
+	assert.Equal(t, []Config{createCfg("rg")}, lock.FailedConfigs(configs, ActionUpgrade))
+}
+
+func TestUpgradeSkipsPackagesInstalledDuringTheRun(t *testing.T) {
+	Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	instructions.Logger = Logger
+	fresh := createCfg("fresh")
+	fresh.Requirements.Install = &installInstruction{Method: instructions.Bash, Pkg: "true"}
+	old := createCfg("old")
+	old.Requirements.Install = &installInstruction{Method: instructions.Bash, Pkg: "true"}
+	old.InstallInfo.IsInstalled = true
+	lock := Lockfile{Configs: []Config{fresh, old}}
+	report := NewReport("upgrade")
+
+	installed := Install(t.Context(), &lock, lock.Configs, report)
+	lock.UpdateInstallInfo(installed)
+	forUpdate := Upgrade(t.Context(), &lock, lock.Configs, []string{"fresh"}, report)
+
+	assert.Equal(t, []string{"old"}, slices.Collect(maps.Keys(forUpdate)))
+	assert.Len(t, report.Entries, 2)
+	assert.Equal(t, ActionInstall, report.Entries[0].Action)
+	assert.Equal(t, ReportEntry{Owner: "old", Action: ActionUpgrade, Subject: "bash:true", Cmd: "true"}, withoutDuration(report.Entries[1]))
+}
@@ -0,0 +1,34 @@
+package lib
+
+import (
+	"context"
+	"time"
+)
+
+// delay before the first retry, it doubles with every further attempt
+var retryDelay = 5 * time.Second
+
+// retry calls f until it succeeds or the retries of the instruction (see
+// InstallMethod.Retries) run out, nothing is retried once ctx is done
+func retry(ctx context.Context, owner string, inst installInstruction, f func() error) error {
+	retries, err := inst.Method.Retries(inst.Options)
+	if err != nil {
+		return err
+	}
+
+	delay := retryDelay
+	for attempt := 1; ; attempt++ {
+		err = f()
+		if err == nil || attempt > retries || ctx.Err() != nil {
+			return err
+		}
+
+		Logger.Warn("attempt failed, retrying", "cfgName", owner, "pkg", inst.String(), "attempt", attempt, "retries", retries, "delay", delay, "err", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,64 @@
+package lib
+
+import (
+	"blanktiger/hm/instructions"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryUntilSuccess(t *testing.T) {
+	Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	retryDelay = time.Millisecond
+	t.Cleanup(func() { retryDelay = 5 * time.Second })
+	inst := installInstruction{Method: instructions.Cargo, Pkg: "ripgrep"}
+
+	attempts := 0
+	err := retry(t.Context(), "rg", inst, func() error {
+		attempts++
+		if attempts < 3 {
+			return errors.New("network is unreachable")
+		}
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, attempts)
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	retryDelay = time.Millisecond
+	t.Cleanup(func() { retryDelay = 5 * time.Second })
+	inst := installInstruction{Method: instructions.Apt, Pkg: "fish", Options: instructions.Options{instructions.RetriesOpt: "1"}}
+
+	attempts := 0
+	err := retry(t.Context(), "fish", inst, func() error {
+		attempts++
+		return errors.New("network is unreachable")
+	})
+
+	assert.ErrorContains(t, err, "network is unreachable")
+	assert.Equal(t, 2, attempts)
+}
+
+func TestRetryStopsWhenCancelled(t *testing.T) {
+	Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	ctx, cancel := context.WithCancel(t.Context())
+	inst := installInstruction{Method: instructions.Cargo, Pkg: "ripgrep"}
+
+	attempts := 0
+	err := retry(ctx, "rg", inst, func() error {
+		attempts++
+		cancel()
+		return context.Canceled
+	})
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 1, attempts)
+}
@@ -0,0 +1,248 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+)
+
+// logs of older runs are removed when a new run starts logging
+const KEPT_RUN_LOGS = 20
+
+// RunLog keeps output of the commands run for each config during a single run
+// in `<logs dir>/<run id>/<config>.log`
+type RunLog struct {
+	Id      string
+	logsDir string
+}
+
+// CmdLog is the log of the current run, commands aren't logged if it's nil
+var CmdLog *RunLog = nil
+
+// NewRunLog doesn't touch the filesystem, the directory of the run is created
+// once the first command is logged
+func NewRunLog(logsDir string) *RunLog {
+	// NOTE: run ids sort chronologically, so the last run is easy to find
+	id := fmt.Sprintf("%s-%d", time.Now().UTC().Format("20060102T150405Z"), os.Getpid())
+	return &RunLog{Id: id, logsDir: logsDir}
+}
+
+func (l *RunLog) Dir() string {
+	return l.logsDir + "/" + l.Id
+}
+
+// LogFileName is the name of the log of an owner (config name or
+// GLOBAL_DEPS_OWNER)
+func LogFileName(owner string) string {
+	return strings.ReplaceAll(owner, "/", "-") + ".log"
+}
+
+// opens the log of the owner for appending and writes a header describing the
+// command, nil file is returned if commands aren't logged
+func (l *RunLog) open(owner string, cmd *exec.Cmd) (*os.File, error) {
+	if l == nil {
+		return nil, nil
+	}
+
+	if _, err := os.Stat(l.Dir()); os.IsNotExist(err) {
+		err = os.MkdirAll(l.Dir(), 0o700)
+		if err != nil {
+			return nil, err
+		}
+		pruneRunLogs(l.logsDir, KEPT_RUN_LOGS)
+	}
+
+	// NOTE: the environment can contain secrets, so only the user can read
+	// the logs
+	file, err := os.OpenFile(l.Dir()+"/"+LogFileName(owner), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	header := fmt.Sprintf("==> %s\ncmd: %s\nenv:\n", now(), strings.Join(cmd.Args, " "))
+	for _, kv := range env {
+		header += "  " + redactEnv(kv) + "\n"
+	}
+	header += "output:\n"
+	_, err = file.WriteString(header)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+func writeLogFooter(file *os.File, err error, duration time.Duration) {
+	exitCode := 0
+	if err != nil {
+		exitCode = -1
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			exitCode = exitErr.ExitCode()
+		}
+	}
+	footer := fmt.Sprintf("==> exit code: %d, took %s\n", exitCode, duration.Round(time.Millisecond))
+	if err != nil {
+		footer += fmt.Sprintf("==> error: %s\n", err)
+	}
+	_, err = file.WriteString(footer + "\n")
+	if err != nil {
+		Logger.Warn("couldn't finish the log of a command", "path", file.Name(), "err", err)
+	}
+}
+
+// values of variables which names suggest a secret are not written to the logs
+func redactEnv(kv string) string {
+	name, _, _ := strings.Cut(kv, "=")
+	upper := strings.ToUpper(name)
+	for _, secret := range []string{"TOKEN", "SECRET", "PASSWORD", "PASSWD", "KEY", "CREDENTIAL"} {
+		if strings.Contains(upper, secret) {
+			return name + "=<redacted>"
+		}
+	}
+	return kv
+}
+
+func runLogDirs(logsDir string) ([]string, error) {
+	entries, err := os.ReadDir(logsDir)
+	if err != nil {
+		return nil, err
+	}
+	res := []string{}
+	for _, e := range entries {
+		if e.IsDir() {
+			res = append(res, e.Name())
+		}
+	}
+	slices.Sort(res)
+	return res, nil
+}
+
+func pruneRunLogs(logsDir string, kept int) {
+	runs, err := runLogDirs(logsDir)
+	if err != nil || len(runs) <= kept {
+		return
+	}
+	for _, run := range runs[:len(runs)-kept] {
+		err = os.RemoveAll(logsDir + "/" + run)
+		if err != nil {
+			Logger.Warn("couldn't remove logs of an old run", "run", run, "err", err)
+		}
+	}
+}
+
+// LastRunLog finds the directory of the most recent run and the log files in
+// it (sorted by name)
+func LastRunLog(logsDir string) (dir string, files []string, err error) {
+	runs, err := runLogDirs(logsDir)
+	if err != nil && !os.IsNotExist(err) {
+		return "", nil, err
+	}
+	if len(runs) == 0 {
+		return "", nil, fmt.Errorf("there are no logs in '%s' yet", logsDir)
+	}
+
+	dir = logsDir + "/" + runs[len(runs)-1]
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", nil, err
+	}
+	files = []string{}
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".log") {
+			files = append(files, e.Name())
+		}
+	}
+	return dir, files, nil
+}
+
+// commands running longer than this are interrupted, 0 means no timeout
+var CmdTimeout time.Duration = 0
+
+// how long an interrupted command has to exit before it's killed
+const KILL_DELAY = 10 * time.Second
+
+// runs the command attached to the terminal, its output is also appended to
+// the log of the owner, once ctx is done (or CmdTimeout runs out) the command
+// gets SIGINT, like it would after C-c in the terminal
+func run(ctx context.Context, owner string, args ...string) error {
+	return runWithEnv(ctx, owner, nil, args...)
+}
+
+// like run, env (`NAME=value`) is added to the environment of hm
+func runWithEnv(ctx context.Context, owner string, env []string, args ...string) error {
+	if CmdTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, CmdTimeout)
+		defer cancel()
+	}
+	execCmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	execCmd.Cancel = func() error {
+		return execCmd.Process.Signal(os.Interrupt)
+	}
+	execCmd.WaitDelay = KILL_DELAY
+	if env != nil {
+		execCmd.Env = append(os.Environ(), env...)
+	}
+
+	execCmd.Stdin = os.Stdin
+	execCmd.Stdout = Stdout
+	execCmd.Stderr = os.Stderr
+	if Jobs > 1 {
+		// NOTE: several commands can't share the terminal, so they can't
+		// ask anything (e.g. sudo has to be authenticated beforehand)
+		execCmd.Stdin = nil
+		stdout := newPrefixWriter(owner, Stdout)
+		stderr := newPrefixWriter(owner, os.Stderr)
+		defer stdout.flush()
+		defer stderr.flush()
+		execCmd.Stdout = stdout
+		execCmd.Stderr = stderr
+	}
+
+	logFile, err := CmdLog.open(owner, execCmd)
+	if err != nil {
+		Logger.Warn("couldn't open the log of the command, running it without logging", "owner", owner, "err", err)
+	}
+	if logFile != nil {
+		defer logFile.Close()
+		// NOTE: output goes through a pipe, so some programs stop using
+		// colors, stdin is still the terminal (e.g. for sudo)
+		execCmd.Stdout = io.MultiWriter(execCmd.Stdout, logFile)
+		execCmd.Stderr = io.MultiWriter(execCmd.Stderr, logFile)
+	}
+
+	start := time.Now()
+	err = execCmd.Start()
+	if err == nil {
+		err = execCmd.Wait()
+	}
+	err = cancelCause(ctx, err)
+	if logFile != nil {
+		writeLogFooter(logFile, err, time.Since(start))
+	}
+	return err
+}
+
+// explains why the command failed if it was interrupted, so that the
+// interruption can be told apart from the command failing on its own
+func cancelCause(ctx context.Context, err error) error {
+	ctxErr := ctx.Err()
+	if err == nil || ctxErr == nil || errors.Is(err, ctxErr) {
+		return err
+	}
+	if errors.Is(ctxErr, context.DeadlineExceeded) {
+		return fmt.Errorf("timed out after %s: %w", CmdTimeout, err)
+	}
+	return fmt.Errorf("%w: %w", ctxErr, err)
+}
@@ -0,0 +1,98 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunAppendsOutputToTheLogOfTheOwner(t *testing.T) {
+	Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	logsDir := t.TempDir()
+	CmdLog = NewRunLog(logsDir)
+	t.Cleanup(func() { CmdLog = nil })
+	out := &bytes.Buffer{}
+	Stdout = out
+	t.Cleanup(func() { Stdout = os.Stdout })
+
+	err := run(t.Context(), "fish", "echo", "installed")
+	assert.NoError(t, err)
+	err = run(t.Context(), "fish", "false")
+	assert.Error(t, err)
+
+	assert.Equal(t, "installed\n", out.String())
+	dir, files, err := LastRunLog(logsDir)
+	assert.NoError(t, err)
+	assert.Equal(t, CmdLog.Dir(), dir)
+	assert.Equal(t, []string{"fish.log"}, files)
+	txt, err := os.ReadFile(dir + "/fish.log")
+	assert.NoError(t, err)
+	assert.Contains(t, string(txt), "cmd: echo installed\n")
+	assert.Contains(t, string(txt), "output:\ninstalled\n==> exit code: 0")
+	assert.Contains(t, string(txt), "cmd: false\n")
+	assert.Contains(t, string(txt), "==> exit code: 1")
+}
+
+func TestPruneRunLogsKeepsTheNewest(t *testing.T) {
+	Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	logsDir := t.TempDir()
+	for _, run := range []string{"20250101T000000Z-1", "20250102T000000Z-1", "20250103T000000Z-1"} {
+		assert.NoError(t, os.Mkdir(logsDir+"/"+run, 0o700))
+	}
+
+	pruneRunLogs(logsDir, 2)
+
+	runs, err := runLogDirs(logsDir)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"20250102T000000Z-1", "20250103T000000Z-1"}, runs)
+}
+
+func TestLastRunLogWithoutLogs(t *testing.T) {
+	_, _, err := LastRunLog(t.TempDir() + "/logs")
+
+	assert.ErrorContains(t, err, "there are no logs")
+}
+
+func TestRedactEnv(t *testing.T) {
+	assert.Equal(t, "PATH=/usr/bin", redactEnv("PATH=/usr/bin"))
+	assert.Equal(t, "GITHUB_TOKEN=<redacted>", redactEnv("GITHUB_TOKEN=ghp_123"))
+}
+
+func TestLogFileName(t *testing.T) {
+	assert.Equal(t, "nvim.log", LogFileName("nvim"))
+	assert.Equal(t, "config-DEPENDENCIES.log", LogFileName(GLOBAL_DEPS_OWNER))
+}
+
+func TestRunTimesOut(t *testing.T) {
+	Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	CmdTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { CmdTimeout = 0 })
+
+	start := time.Now()
+	err := run(t.Context(), "fish", "sleep", "5")
+
+	assert.ErrorContains(t, err, "timed out after 100ms")
+	assert.False(t, errors.Is(err, context.Canceled))
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestRunInterruptsTheCommandWhenCancelled(t *testing.T) {
+	Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	ctx, cancel := context.WithCancel(t.Context())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	err := run(ctx, "fish", "sleep", "5")
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorContains(t, err, "signal: interrupt")
+
+	err = run(ctx, "fish", "true")
+	assert.ErrorIs(t, err, context.Canceled)
+}
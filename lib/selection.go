@@ -0,0 +1,90 @@
+package lib
+
+import (
+	"slices"
+	"strings"
+)
+
+// SelectionChange describes what persisting the TUI selection changes in the
+// source directory
+type SelectionChange struct {
+	Hidden      []string
+	Unhidden    []string
+	AddedDeps   []string
+	RemovedDeps []string
+}
+
+func (s SelectionChange) IsEmpty() bool {
+	return len(s.Hidden) == 0 && len(s.Unhidden) == 0 && len(s.AddedDeps) == 0 && len(s.RemovedDeps) == 0
+}
+
+// PendingConfigSelection finds configs which directories are going to be
+// renamed by PersistConfigSelection, so it has to be called before it
+func (l *Lockfile) PendingConfigSelection() (hidden, unhidden []string) {
+	hidden, unhidden = []string{}, []string{}
+	for _, cfg := range l.Configs {
+		if cfgIsHiddenBasedOnFrom(cfg.From) {
+			unhidden = append(unhidden, cfg.Name)
+		}
+	}
+	for _, cfg := range l.HiddenConfigs {
+		if !cfgIsHiddenBasedOnFrom(cfg.From) {
+			hidden = append(hidden, cfg.Name)
+		}
+	}
+	return hidden, unhidden
+}
+
+// GlobalDepsChange compares global dependencies package by package, so lines
+// with many packages don't have to match exactly
+func GlobalDepsChange(before, after []GlobalDependency) (added, removed []string) {
+	keys := func(deps []GlobalDependency) []string {
+		res := []string{}
+		for _, dep := range deps {
+			for _, single := range splitPackages(*dep.Instruction) {
+				res = append(res, single.String())
+			}
+		}
+		return res
+	}
+	keysBefore, keysAfter := keys(before), keys(after)
+
+	added, removed = []string{}, []string{}
+	for _, key := range keysAfter {
+		if !slices.Contains(keysBefore, key) {
+			added = append(added, key)
+		}
+	}
+	for _, key := range keysBefore {
+		if !slices.Contains(keysAfter, key) {
+			removed = append(removed, key)
+		}
+	}
+	return added, removed
+}
+
+// commit message subject and body, e.g. `hm: hide tmux; remove system:htop`
+func (s SelectionChange) commitMessage() (string, string) {
+	parts, body := []string{}, []string{}
+	add := func(verb, title string, items []string) {
+		if len(items) == 0 {
+			return
+		}
+		parts = append(parts, verb+" "+strings.Join(items, ", "))
+		body = append(body, title+":")
+		for _, item := range items {
+			body = append(body, "- "+item)
+		}
+	}
+	add("hide", "Hidden configs", s.Hidden)
+	add("unhide", "Unhidden configs", s.Unhidden)
+	add("add", "Added global dependencies", s.AddedDeps)
+	add("remove", "Removed global dependencies", s.RemovedDeps)
+
+	subject := "hm: " + strings.Join(parts, "; ")
+	// NOTE: long subjects are cut, the body lists everything anyway
+	if runes := []rune(subject); len(runes) > 72 {
+		subject = string(runes[:69]) + "..."
+	}
+	return subject, strings.Join(body, "\n")
+}
@@ -0,0 +1,41 @@
+package lib
+
+import (
+	"blanktiger/hm/instructions"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGlobalDepsChange(t *testing.T) {
+	before := []GlobalDependency{{Instruction: &installInstruction{Method: instructions.System, Pkg: "git htop"}}}
+	after := []GlobalDependency{
+		{Instruction: &installInstruction{Method: instructions.System, Pkg: "git"}},
+		{Instruction: &installInstruction{Method: instructions.Cargo, Pkg: "bat"}},
+	}
+
+	added, removed := GlobalDepsChange(before, after)
+
+	assert.Equal(t, []string{"cargo:bat"}, added)
+	assert.Equal(t, []string{"system:htop"}, removed)
+}
+
+func TestSelectionCommitMessage(t *testing.T) {
+	change := SelectionChange{Hidden: []string{"tmux", "zsh"}, Unhidden: []string{"fish"}}
+
+	subject, body := change.commitMessage()
+
+	assert.Equal(t, "hm: hide tmux, zsh; unhide fish", subject)
+	assert.Equal(t, "Hidden configs:\n- tmux\n- zsh\nUnhidden configs:\n- fish", body)
+}
+
+func TestSelectionCommitMessageCutsLongSubjectsByRunes(t *testing.T) {
+	change := SelectionChange{Hidden: []string{strings.Repeat("ž", 80)}}
+
+	subject, _ := change.commitMessage()
+
+	assert.True(t, utf8.ValidString(subject))
+	assert.Equal(t, 72, utf8.RuneCountInString(subject))
+}
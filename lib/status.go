@@ -0,0 +1,84 @@
+package lib
+
+import (
+	"errors"
+	"os/exec"
+)
+
+type PackageStatus struct {
+	// config name, or empty for global dependencies
+	Owner       string
+	Instruction string
+	Installed   bool
+	// installed according to the lockfile, but the check command of the
+	// method doesn't find it anymore
+	Missing bool
+	Pin     string
+	// live version if the package manager reports it, otherwise the version
+	// recorded during installation
+	Version      string
+	PinSatisfied bool
+}
+
+func newPackageStatus(owner string, inst installInstruction, info installInfo) PackageStatus {
+	status := PackageStatus{
+		Owner:        owner,
+		Instruction:  inst.String(),
+		Installed:    info.IsInstalled,
+		PinSatisfied: true,
+	}
+	if !inst.Version.IsEmpty() {
+		status.Pin = inst.Version.String()
+	}
+	if !info.IsInstalled {
+		return status
+	}
+
+	pkg := info.ResolvedPkg
+	if pkg == "" {
+		pkg = inst.Method.ResolvePkg(inst.Pkg)
+	}
+	status.Missing = isMissing(inst, pkg)
+	status.Version = recordInstalledVersion(inst, pkg)
+	if status.Version == "" {
+		status.Version = info.InstalledVersion
+	}
+	if !inst.Version.IsEmpty() {
+		status.PinSatisfied = status.Version != "" && inst.Version.Satisfied(status.Version)
+	}
+	return status
+}
+
+// reports whether the check command of the method fails for the package,
+// packages of methods without a check command are never missing
+func isMissing(inst installInstruction, pkg string) bool {
+	cmd, err := inst.Method.CreateCheckCmd(pkg, inst.Options)
+	if err != nil {
+		Logger.Debug("couldn't check if the package is installed", "pkg", inst.Pkg, "err", err)
+		return false
+	}
+	_, err = output(cmd)
+	// NOTE: only a check that ran and failed says the package is missing, a
+	// missing package manager is reported by `hm doctor`
+	var exitErr *exec.ExitError
+	return errors.As(err, &exitErr)
+}
+
+// Status reports installation state of every package of active configs and
+// global dependencies, checking that installed packages are still there and
+// that their versions satisfy the pins
+func Status(lock *Lockfile) []PackageStatus {
+	res := []PackageStatus{}
+	for _, dep := range lock.GlobalDependencies {
+		res = append(res, newPackageStatus("", *dep.Instruction, dep.InstallInfo))
+	}
+
+	for _, cfg := range lock.Configs {
+		if cfg.Requirements.Install == nil {
+			continue
+		}
+		res = append(res, newPackageStatus(cfg.Name, *cfg.Requirements.Install, cfg.InstallInfo))
+	}
+
+	return res
+}
@@ -0,0 +1,31 @@
+package lib
+
+import (
+	"blanktiger/hm/instructions"
+	"io"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusReportsPackagesRemovedOutsideOfHm(t *testing.T) {
+	Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	instructions.Logger = Logger
+	srcDir := t.TempDir()
+	methods := "[probe]\ninstall = true {pkg}\ncheck = test -e {pkg}\n"
+	assert.NoError(t, os.WriteFile(srcDir+instructions.METHODS_PATH_POSTFIX, []byte(methods), 0o644))
+	assert.NoError(t, instructions.LoadCustomMethods(srcDir))
+	present, removed := createCfg("present"), createCfg("removed")
+	present.Requirements.Install = &installInstruction{Method: "probe", Pkg: srcDir}
+	present.InstallInfo.IsInstalled = true
+	removed.Requirements.Install = &installInstruction{Method: "probe", Pkg: srcDir + "/gone"}
+	removed.InstallInfo.IsInstalled = true
+
+	statuses := Status(&Lockfile{Configs: []Config{present, removed}})
+
+	assert.Len(t, statuses, 2)
+	assert.False(t, statuses[0].Missing)
+	assert.True(t, statuses[1].Missing)
+}
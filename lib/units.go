@@ -0,0 +1,271 @@
+package lib
+
+import (
+	i "blanktiger/hm/instructions"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+type UnitAction string
+
+const (
+	UnitEnable UnitAction = "enable"
+	UnitStart  UnitAction = "start"
+	// only done again when the files of the config change
+	UnitRestart UnitAction = "restart"
+	// also stops the unit
+	UnitDisable UnitAction = "disable"
+)
+
+var unitActions = []UnitAction{UnitEnable, UnitStart, UnitRestart, UnitDisable}
+
+// Unit is a systemd user unit with the actions declared for it in UNITS of a
+// config, e.g. `syncthing.service enable start`
+type Unit struct {
+	Name    string       `json:"name"`
+	Actions []UnitAction `json:"actions"`
+}
+
+// owner of `systemctl --user daemon-reload`
+const SYSTEMD_OWNER = "systemd"
+
+// command the units are managed with, the action and the unit are appended
+var systemctl = []string{"systemctl", "--user"}
+
+// parses UNITS of a config, a missing file means no units, errors of all lines
+// are returned together
+func parseUnits(dir string) ([]Unit, error) {
+	path := dir + UNITS_PATH_POSTFIX
+	txt, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []Unit{}, nil
+		}
+		return nil, err
+	}
+	return parseUnitLines(path, string(txt))
+}
+
+// lines starting with # are comments
+func parseUnitLines(path, txt string) ([]Unit, error) {
+	units := []Unit{}
+	errs := []error{}
+	lineNr := 0
+	for line := range strings.Lines(txt) {
+		lineNr++
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		unit := Unit{Name: fields[0], Actions: []UnitAction{}}
+		for _, field := range fields[1:] {
+			unit.Actions = append(unit.Actions, UnitAction(field))
+		}
+		err := validateUnit(unit, units)
+		if err != nil {
+			errs = append(errs, i.ParseError{Path: path, Line: lineNr, Text: strings.TrimSpace(line), Err: err})
+			continue
+		}
+		units = append(units, unit)
+	}
+	return units, errors.Join(errs...)
+}
+
+// units are the ones declared on the previous lines
+func validateUnit(unit Unit, units []Unit) error {
+	if len(unit.Actions) == 0 {
+		return errors.New("expected a unit followed by actions")
+	}
+	if slices.ContainsFunc(units, func(u Unit) bool { return u.Name == unit.Name }) {
+		return fmt.Errorf("'%s' is declared more than once", unit.Name)
+	}
+	for idx, action := range unit.Actions {
+		if !slices.Contains(unitActions, action) {
+			return fmt.Errorf("unknown action '%s', expected one of: enable, start, restart, disable", action)
+		}
+		if slices.Contains(unit.Actions[:idx], action) {
+			return fmt.Errorf("action '%s' is repeated", action)
+		}
+	}
+	if slices.Contains(unit.Actions, UnitDisable) && len(unit.Actions) > 1 {
+		return errors.New("'disable' can't be combined with other actions")
+	}
+	return nil
+}
+
+// what has to be done to bring a unit to the declared state
+type unitStep struct {
+	unit    Unit
+	actions []UnitAction
+	// the unit isn't declared anymore, it's dropped from the applied units
+	// once it's disabled
+	forget bool
+}
+
+// planUnits compares the declared units of a config with the applied ones,
+// change is what changed when the files of the config were deployed during
+// this run (see deployChange), units declared with restart are restarted then
+// and all the declared actions are done again for added configs or when the
+// mode changed, as the units might have been disabled in the meantime
+func planUnits(cfg Config, hidden bool, change string) []unitStep {
+	steps := []unitStep{}
+	declared := cfg.Units
+	if hidden {
+		declared = []Unit{}
+	}
+
+	redeployed := change == ChangeAdded || change == ChangeMode
+	for _, unit := range declared {
+		idx := slices.IndexFunc(cfg.AppliedUnits, func(u Unit) bool { return u.Name == unit.Name })
+		switch {
+		case idx == -1 || !slices.Equal(cfg.AppliedUnits[idx].Actions, unit.Actions) || redeployed:
+			steps = append(steps, unitStep{unit: unit, actions: unit.Actions})
+		case change != "" && slices.Contains(unit.Actions, UnitRestart):
+			steps = append(steps, unitStep{unit: unit, actions: []UnitAction{UnitRestart}})
+		}
+	}
+
+	for _, applied := range cfg.AppliedUnits {
+		if slices.ContainsFunc(declared, func(u Unit) bool { return u.Name == applied.Name }) {
+			continue
+		}
+		step := unitStep{unit: applied, actions: []UnitAction{UnitDisable}, forget: true}
+		if slices.Contains(applied.Actions, UnitDisable) {
+			step.actions = []UnitAction{}
+		}
+		steps = append(steps, step)
+	}
+	return steps
+}
+
+// tells what changed when the config was deployed during this run, empty if it
+// wasn't deployed again since the lockfile `before` was saved (or wasn't
+// deployed at all, e.g. by `hm install`)
+func unitChange(before, after *Lockfile, cfg Config) string {
+	if cfg.DeployedChecksum == "" {
+		return ""
+	}
+	return deployChange(before, after.Mode, cfg, cfg.DeployedChecksum)
+}
+
+// reports whether the target of the config contains (or is inside) the
+// directory of systemd user units
+func containsUserUnits(cfg Config) bool {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return false
+	}
+	unitsDir := configDir + "/systemd/user"
+	for _, path := range [][2]string{{cfg.To, unitsDir}, {unitsDir, cfg.To}} {
+		rel, err := filepath.Rel(path[0], path[1])
+		if err == nil && !strings.HasPrefix(rel, "..") {
+			return true
+		}
+	}
+	return false
+}
+
+// unit steps of a single config
+type unitPlan struct {
+	cfg    *Config
+	steps  []unitStep
+	hidden bool
+}
+
+// ApplyUnits brings systemd user units of the active configs to the states
+// declared in their UNITS files, it's meant to run after the configs were
+// deployed and their packages installed, units that aren't declared anymore
+// are disabled, `daemon-reload` runs first if anything is going to be done or
+// unit files were deployed again, applied states are kept in the lockfile
+// `after`
+func ApplyUnits(ctx context.Context, before, after *Lockfile, report *Report) {
+	plans := []unitPlan{}
+	reload := false
+	for idx := range after.Configs {
+		cfg := &after.Configs[idx]
+		change := unitChange(before, after, *cfg)
+		steps := planUnits(*cfg, false, change)
+		reload = reload || len(steps) > 0 || (change != "" && (len(cfg.Units) > 0 || containsUserUnits(*cfg)))
+		plans = append(plans, unitPlan{cfg: cfg, steps: steps})
+	}
+	applyUnitPlans(ctx, plans, reload, report)
+}
+
+// DisableHiddenUnits disables the applied units of hidden configs, it has to
+// run before their files are removed
+func DisableHiddenUnits(ctx context.Context, after *Lockfile, report *Report) {
+	plans := []unitPlan{}
+	reload := false
+	for idx := range after.HiddenConfigs {
+		cfg := &after.HiddenConfigs[idx]
+		steps := planUnits(*cfg, true, "")
+		reload = reload || len(steps) > 0
+		plans = append(plans, unitPlan{cfg: cfg, steps: steps, hidden: true})
+	}
+	applyUnitPlans(ctx, plans, reload, report)
+}
+
+func applyUnitPlans(ctx context.Context, plans []unitPlan, reload bool, report *Report) {
+	if !reload {
+		return
+	}
+
+	if _, err := exec.LookPath(systemctl[0]); err != nil && !slices.ContainsFunc(plans, func(p unitPlan) bool { return len(p.steps) > 0 }) {
+		Logger.Debug("systemctl isn't available, not reloading the unit files", "err", err)
+		return
+	}
+	err := systemctlRun(ctx, SYSTEMD_OWNER, report, "daemon-reload")
+	if err != nil {
+		Logger.Error("couldn't reload the unit files, trying to continue", "err", err)
+	}
+
+	for _, p := range plans {
+		for _, step := range p.steps {
+			if ctx.Err() != nil {
+				return
+			}
+			Logger.Info("applying the state of a unit", "cfgName", p.cfg.Name, "unit", step.unit.Name, "actions", step.actions, "hidden", p.hidden)
+			if !applyUnitStep(ctx, p.cfg.Name, step, report) {
+				continue
+			}
+			p.cfg.AppliedUnits = slices.DeleteFunc(slices.Clone(p.cfg.AppliedUnits), func(u Unit) bool {
+				return u.Name == step.unit.Name
+			})
+			if !step.forget {
+				p.cfg.AppliedUnits = append(p.cfg.AppliedUnits, step.unit)
+			}
+		}
+	}
+}
+
+// ok is false if any of the actions failed, the rest of them is skipped
+func applyUnitStep(ctx context.Context, owner string, step unitStep, report *Report) (ok bool) {
+	for _, action := range step.actions {
+		args := []string{string(action), step.unit.Name}
+		if action == UnitDisable {
+			args = []string{string(action), "--now", step.unit.Name}
+		}
+		err := systemctlRun(ctx, owner, report, args...)
+		if err != nil {
+			Logger.Error("something went wrong while applying the state of a unit, trying to continue", "cfgName", owner, "unit", step.unit.Name, "action", action, "err", err)
+			return false
+		}
+	}
+	return true
+}
+
+func systemctlRun(ctx context.Context, owner string, report *Report, args ...string) error {
+	cmd := slices.Concat(systemctl, args)
+	start := time.Now()
+	err := run(ctx, owner, cmd...)
+	report.add(owner, ActionUnit, args[len(args)-1], strings.Join(cmd, " "), start, err)
+	return err
+}
@@ -0,0 +1,118 @@
+package lib
+
+import (
+	"blanktiger/hm/instructions"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseUnits(t *testing.T) {
+	units, err := parseUnitLines("UNITS", "# units of syncthing\nsyncthing.service enable start\n\n  ssh-agent.socket   disable\n")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []Unit{
+		{Name: "syncthing.service", Actions: []UnitAction{UnitEnable, UnitStart}},
+		{Name: "ssh-agent.socket", Actions: []UnitAction{UnitDisable}},
+	}, units)
+}
+
+func TestParseUnitsReportsEveryBrokenLine(t *testing.T) {
+	txt := "a.service\nb.service enable stop\nc.service disable start\nd.service start start\ne.service enable\ne.service start\n"
+
+	units, err := parseUnitLines("UNITS", txt)
+
+	assert.Equal(t, []Unit{{Name: "e.service", Actions: []UnitAction{UnitEnable}}}, units)
+	errs := SplitErrors(err)
+	assert.Len(t, errs, 5)
+	lines := []int{}
+	for _, err := range errs {
+		var parseErr instructions.ParseError
+		assert.ErrorAs(t, err, &parseErr)
+		lines = append(lines, parseErr.Line)
+	}
+	assert.Equal(t, []int{1, 2, 3, 4, 6}, lines)
+}
+
+func TestPlanUnits(t *testing.T) {
+	syncthing := Unit{Name: "syncthing.service", Actions: []UnitAction{UnitEnable, UnitRestart}}
+	agent := Unit{Name: "ssh-agent.socket", Actions: []UnitAction{UnitEnable}}
+	cfg := createCfg("syncthing")
+	cfg.Units = []Unit{syncthing}
+	cfg.AppliedUnits = []Unit{syncthing, agent}
+
+	assert.Equal(t, []unitStep{
+		{unit: agent, actions: []UnitAction{UnitDisable}, forget: true},
+	}, planUnits(cfg, false, ""), "units that aren't declared anymore are disabled")
+	assert.Equal(t, []unitStep{
+		{unit: syncthing, actions: []UnitAction{UnitRestart}},
+		{unit: agent, actions: []UnitAction{UnitDisable}, forget: true},
+	}, planUnits(cfg, false, ChangeModified), "changed configs are restarted")
+	assert.Equal(t, []unitStep{
+		{unit: syncthing, actions: []UnitAction{UnitDisable}, forget: true},
+		{unit: agent, actions: []UnitAction{UnitDisable}, forget: true},
+	}, planUnits(cfg, true, ""), "hidden configs are disabled")
+
+	cfg.Units = []Unit{{Name: "syncthing.service", Actions: []UnitAction{UnitEnable, UnitStart}}}
+	cfg.AppliedUnits = []Unit{syncthing}
+	assert.Equal(t, []unitStep{
+		{unit: cfg.Units[0], actions: []UnitAction{UnitEnable, UnitStart}},
+	}, planUnits(cfg, false, ""), "changed declarations are applied again")
+
+	cfg.AppliedUnits = cfg.Units
+	for _, change := range []string{ChangeAdded, ChangeMode} {
+		assert.Equal(t, []unitStep{
+			{unit: cfg.Units[0], actions: []UnitAction{UnitEnable, UnitStart}},
+		}, planUnits(cfg, false, change), "%s configs are enabled again", change)
+	}
+	assert.Empty(t, planUnits(cfg, false, ChangeModified))
+}
+
+func TestApplyUnits(t *testing.T) {
+	Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	prevStdout, prevSystemctl := Stdout, systemctl
+	defer func() { Stdout, systemctl = prevStdout, prevSystemctl }()
+	Stdout = io.Discard
+	out := t.TempDir() + "/systemctl.out"
+	// NOTE: records the arguments, starting mpd fails
+	systemctl = []string{"bash", "-c", `echo "$@" >> ` + out + `; [ "$*" != "start mpd.service" ]`, "systemctl"}
+
+	syncthing := createCfg("syncthing")
+	syncthing.Units = []Unit{{Name: "syncthing.service", Actions: []UnitAction{UnitEnable, UnitStart}}}
+	mpd := createCfg("mpd")
+	mpd.Units = []Unit{{Name: "mpd.service", Actions: []UnitAction{UnitStart}}}
+	tmux := createCfg("tmux")
+	tmux.AppliedUnits = []Unit{{Name: "tmux.service", Actions: []UnitAction{UnitEnable}}}
+	after := Lockfile{Configs: []Config{syncthing, mpd}, HiddenConfigs: []Config{tmux}}
+	report := NewReport("apply")
+
+	DisableHiddenUnits(t.Context(), &after, report)
+	ApplyUnits(t.Context(), &Lockfile{}, &after, report)
+
+	calls, err := os.ReadFile(out)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"daemon-reload",
+		"disable --now tmux.service",
+		"daemon-reload",
+		"enable syncthing.service",
+		"start syncthing.service",
+		"start mpd.service",
+	}, strings.Split(strings.TrimSpace(string(calls)), "\n"))
+	assert.Equal(t, syncthing.Units, after.Configs[0].AppliedUnits)
+	assert.Empty(t, after.Configs[1].AppliedUnits, "failed units are applied again next time")
+	assert.Empty(t, after.HiddenConfigs[0].AppliedUnits)
+	assert.Equal(t, PartialFailureError{Failed: 1}, report.Err())
+
+	assert.NoError(t, os.Remove(out))
+	report = NewReport("apply")
+	DisableHiddenUnits(t.Context(), &after, report)
+	ApplyUnits(t.Context(), &Lockfile{}, &after, report)
+	calls, err = os.ReadFile(out)
+	assert.NoError(t, err)
+	assert.Equal(t, "daemon-reload\nstart mpd.service\n", string(calls))
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	conf "blanktiger/hm/configuration"
+	"blanktiger/hm/lib"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// prints logs of the last run, either of all the configs or just one
+func logsMain(c *conf.Configuration) error {
+	dir, files, err := lib.LastRunLog(c.LogsDir)
+	if err != nil {
+		return err
+	}
+
+	if len(c.Args) == 1 {
+		name := lib.LogFileName(c.Args[0])
+		if !slices.Contains(files, name) {
+			return fmt.Errorf("no commands were run for '%s' during the last run (%s), logs exist for: %s", c.Args[0], dir, logOwners(files))
+		}
+		files = []string{name}
+	}
+
+	for idx, name := range files {
+		txt, err := os.ReadFile(dir + "/" + name)
+		if err != nil {
+			return err
+		}
+		// NOTE: like tail does it for multiple files
+		if len(files) > 1 {
+			if idx > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("==> %s <==\n", dir+"/"+name)
+		}
+		fmt.Print(string(txt))
+	}
+	return nil
+}
+
+func logOwners(files []string) string {
+	if len(files) == 0 {
+		return "nothing"
+	}
+	owners := []string{}
+	for _, name := range files {
+		owners = append(owners, strings.TrimSuffix(name, ".log"))
+	}
+	return strings.Join(owners, ", ")
+}
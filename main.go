@@ -4,27 +4,149 @@ import (
 	conf "blanktiger/hm/configuration"
 	"blanktiger/hm/instructions"
 	"blanktiger/hm/lib"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"os"
+	"os/signal"
+	"slices"
+	"syscall"
 )
 
 func main() {
-	c := conf.Parse()
+	c, err := conf.Parse(os.Args[1:])
+	if err != nil {
+		os.Exit(handleParseError(c, err))
+	}
+	if c.Command == conf.HelpCmd {
+		if len(c.Args) == 0 {
+			conf.PrintUsage(os.Stdout)
+		} else {
+			conf.PrintCommandUsage(os.Stdout, c.Args[0])
+		}
+		return
+	}
 	c.Display()
-	c.AssertCorrectness()
 
 	lib.Logger = c.Logger
-	instructions.Init(c.Logger)
-	err := _main(&c)
-	if err != nil {
-		c.Logger.Error("program exited with an error", "error", err)
+	if c.Output == conf.OutputJson {
+		lib.Stdout = os.Stderr
+	}
+	lib.CmdLog = lib.NewRunLog(c.LogsDir)
+	// NOTE: commands without --jobs leave it at 0
+	lib.Jobs = max(c.Jobs, 1)
+	lib.CmdTimeout = c.Timeout
+
+	// NOTE: the first C-c lets the running commands exit and saves what was
+	// done, the second one kills hm right away
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	context.AfterFunc(ctx, stop)
+
+	// NOTE: has to happen before initializing the installation methods,
+	// because custom methods and aliases live in the source directory
+	if c.SourceRepo != "" && syncsSourceRepo(c) {
+		err := lib.SyncSourceRepo(c.SourceRepo, c.SourceDir, c.Force)
+		if err != nil {
+			c.Logger.Error("couldn't sync the source repository", "repo", c.SourceRepo, "error", err)
+			os.Exit(1)
+		}
+	}
+
+	err = errors.Join(instructions.Init(c.Logger, c.SourceCfgDir), instructions.LoadProfile(c.ProfilePath))
+	escalationErr := instructions.SetEscalation(c.Escalate)
+	if escalationErr != nil {
+		os.Exit(handleParseError(c, conf.UsageError{Command: c.Command, Msg: escalationErr.Error()}))
+	}
+	if c.Command == conf.DoctorCmd {
+		// NOTE: broken METHODS, ALIASES and profile are reported like any other problem
+		err = doctorMain(&c, err)
+	} else if err != nil {
+		reportError(c, "couldn't initialize installation methods", err)
 		os.Exit(1)
+	} else {
+		err = _main(ctx, &c)
+	}
+	if err != nil {
+		reportError(c, "program exited with an error", err)
+		os.Exit(exitCode(err))
 	}
 }
 
-func _main(c *conf.Configuration) error {
+// only the commands that deploy or install pull the source repository, the
+// rest of them work with whatever was synced last time
+func syncsSourceRepo(c conf.Configuration) bool {
+	return c.Tui || slices.Contains([]string{conf.ApplyCmd, conf.InstallCmd, conf.UpgradeCmd, conf.UninstallCmd, conf.TuiCmd}, c.Command)
+}
+
+// 1 if hm couldn't do what it was asked to, 3 if only some of the configs or
+// packages failed (2 is used for usage errors), 130 if it was interrupted
+func exitCode(err error) int {
+	var partialErr lib.PartialFailureError
+	if errors.As(err, &partialErr) {
+		return 3
+	}
+	if errors.Is(err, context.Canceled) {
+		return 130
+	}
+	return 1
+}
+
+// errors of all the configs are joined together, each of them is printed on
+// its own line, so that they can be fixed at once
+func reportError(c conf.Configuration, msg string, err error) {
+	errs := lib.SplitErrors(err)
+	if len(errs) == 1 {
+		c.Logger.Error(msg, "error", err)
+		return
+	}
+
+	c.Logger.Error(msg, "errors", len(errs))
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, "hm:", err)
+	}
+}
+
+// prints the error (or the requested help) and returns the exit code
+func handleParseError(c conf.Configuration, err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		conf.PrintCommandUsage(os.Stdout, c.Command)
+		return 0
+	}
+
+	fmt.Fprintln(os.Stderr, "hm:", err)
+	var usageErr conf.UsageError
+	if errors.As(err, &usageErr) {
+		if usageErr.Command == "" {
+			fmt.Fprintln(os.Stderr, "run `hm help` to see the available commands")
+		} else {
+			fmt.Fprintf(os.Stderr, "run `hm help %s` to see its usage\n", usageErr.Command)
+		}
+		return 2
+	}
+	return 1
+}
+
+func _main(ctx context.Context, c *conf.Configuration) error {
+	switch c.Command {
+	case conf.StatusCmd:
+		return statusMain(c)
+	case conf.OrphansCmd:
+		return orphansMain(ctx, c)
+	case conf.AdoptCmd:
+		return adoptMain(c)
+	case conf.InitCmd:
+		return lib.InitSourceDir(c)
+	case conf.ConfigCmd:
+		return configMain(c)
+	case conf.LogsCmd:
+		return logsMain(c)
+	}
+
 	if c.Tui {
-		return tuiMain(c)
+		return tuiMain(ctx, c)
 	} else {
-		return cliMain(c)
+		return cliMain(ctx, c)
 	}
 }
@@ -0,0 +1,50 @@
+package main
+
+import (
+	conf "blanktiger/hm/configuration"
+	"blanktiger/hm/lib"
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+)
+
+// lists packages installed by hm that no config or global dependency claims
+// anymore, with --remove they are uninstalled as well
+func orphansMain(ctx context.Context, c *conf.Configuration) error {
+	lockBefore, lock, err := currentLockfiles(c)
+	if err != nil {
+		return err
+	}
+
+	orphans := lock.Orphans()
+	if len(orphans) == 0 {
+		fmt.Println("no orphaned packages")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PACKAGE\tINSTALLED BY\tINSTALLED AT\tCOMMAND")
+	for _, orphan := range orphans {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", orphan.Instruction.String(), orphan.Owner, orphan.InstallTime, orphan.Command)
+	}
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	if !c.RemoveOrphans {
+		return nil
+	}
+
+	// NOTE: only the ledger of the previous lockfile is updated, everything
+	// else has to stay as it was after the last run
+	lockBefore.Ledger = lock.Ledger
+	lockBefore.RunId = lib.CmdLog.Id
+	uninstallErr := lockBefore.UninstallOrphans(ctx, orphans)
+	err = lockBefore.Save(c.LockfilePath, c.DefaultIndent)
+	if err != nil {
+		return err
+	}
+	return uninstallErr
+}
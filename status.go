@@ -0,0 +1,56 @@
+package main
+
+import (
+	conf "blanktiger/hm/configuration"
+	"blanktiger/hm/lib"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+)
+
+func statusMain(c *conf.Configuration) error {
+	_, lock, err := currentLockfiles(c)
+	if err != nil {
+		return err
+	}
+
+	unsatisfied, missing := 0, 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CONFIG\tINSTRUCTION\tVERSION\tSTATUS")
+	for _, status := range lib.Status(lock) {
+		owner := status.Owner
+		if owner == "" {
+			owner = "(global)"
+		}
+		version := status.Version
+		if version == "" {
+			version = "-"
+		}
+
+		state := "ok"
+		if !status.Installed {
+			state = "not installed"
+		} else if status.Missing {
+			state = "missing, installed before but not found anymore"
+			missing++
+		} else if !status.PinSatisfied {
+			state = "doesn't satisfy " + status.Pin
+			unsatisfied++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", owner, status.Instruction, version, state)
+	}
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	errs := []error{}
+	if missing > 0 {
+		errs = append(errs, errors.New(fmt.Sprintf("%d package(s) installed by hm are missing", missing)))
+	}
+	if unsatisfied > 0 {
+		errs = append(errs, errors.New(fmt.Sprintf("%d installed package(s) don't satisfy their version pins", unsatisfied)))
+	}
+	return errors.Join(errs...)
+}
@@ -5,6 +5,7 @@ import (
 	conf "blanktiger/hm/configuration"
 	"blanktiger/hm/instructions"
 	"blanktiger/hm/lib"
+	"context"
 	"fmt"
 	"io"
 	"os"
@@ -119,11 +120,17 @@ type model struct {
 	globalDepsList blist.Model
 	choicesList    blist.Model
 	listHeight     int
+
+	// the user left without applying the selection, interrupted is set for
+	// C-c (as opposed to q)
+	quit        bool
+	interrupted bool
 }
 
 type choices struct {
 	PersistConfigSelection     bool `txt:"Persist config selection"`
 	PersistGlobalDepsSelection bool `txt:"Persist global dependencies selection"`
+	CommitSelection            bool `txt:"Commit persisted selection (if the source directory is a git repository)"`
 }
 
 func initModel(lockfile *lib.Lockfile, conf *configuration.Configuration) model {
@@ -396,9 +403,13 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case tea.KeyMsg:
 		switch msg.(tea.KeyMsg).String() {
 
-		case "ctrl+c", "q":
-			// TODO: maybe there is a nicer way to do this with bubbletea (something builtin)
-			os.Exit(1)
+		case "ctrl+c":
+			m.quit, m.interrupted = true, true
+			return m, tea.Quit
+
+		case "q":
+			m.quit = true
+			return m, tea.Quit
 
 		case " ":
 			return m, m.updateAfterSelectingInList()
@@ -521,7 +532,7 @@ func (m *model) updateAfterSelectingInList() tea.Cmd {
 }
 
 func formatGlobalDep(dep lib.GlobalDependency) string {
-	return string(dep.Instruction.Method) + ":" + dep.Instruction.Pkg
+	return dep.Instruction.String()
 }
 
 type helpKey struct {
@@ -575,7 +586,7 @@ var helpLastScreen = []helpKey{
 	},
 }
 
-func tuiMain(c *conf.Configuration) error {
+func tuiMain(ctx context.Context, c *conf.Configuration) error {
 	for idx, h := range helpGeneral {
 		shortHelpKeysGeneral[idx] = h.shortBinding
 		longHelpKeysGeneral[idx] = h.longBinding
@@ -606,7 +617,7 @@ func tuiMain(c *conf.Configuration) error {
 
 	{
 		m := initModel(lockAfter, c)
-		p := tea.NewProgram(m)
+		p := tea.NewProgram(m, tea.WithContext(ctx))
 
 		_m, err := p.Run()
 		if err != nil {
@@ -614,12 +625,21 @@ func tuiMain(c *conf.Configuration) error {
 		}
 
 		m = _m.(model)
-		return executeBasedOnUserSelection(m)
+		if m.quit {
+			c.Logger.Info("left the TUI, nothing was changed")
+			if m.interrupted {
+				return context.Canceled
+			}
+			return nil
+		}
+		return executeBasedOnUserSelection(ctx, m)
 	}
 }
 
-func executeBasedOnUserSelection(m model) error {
+func executeBasedOnUserSelection(ctx context.Context, m model) error {
 	c := m.conf
+	report := lib.NewReport(c.Command)
+	report.RunId = lib.CmdLog.Id
 	lockBefore, err := lib.ReadOrCreateLockfile(c.LockfilePath)
 	if err != nil {
 		c.Logger.Info("encountered an error while trying to read an existing lockfile (probably doesnt exist), creating a new one instead", "err", err)
@@ -628,7 +648,9 @@ func executeBasedOnUserSelection(m model) error {
 
 	lockAfter := m.lockfile
 
+	change := lib.SelectionChange{}
 	if m.userChoices.PersistConfigSelection {
+		change.Hidden, change.Unhidden = lockAfter.PendingConfigSelection()
 		err = lockAfter.PersistConfigSelection()
 		if err != nil {
 			return err
@@ -636,67 +658,35 @@ func executeBasedOnUserSelection(m model) error {
 	}
 
 	if m.userChoices.PersistGlobalDepsSelection {
-		err = lockAfter.PersistGlobalDepsSelection(c.SourceCfgDir)
+		depsBefore, err := lib.ParseGlobalDependencies(c.SourceCfgDir)
 		if err != nil {
 			return err
 		}
-	}
-
-	lib.CopyInstallInfo(lockBefore, lockAfter)
-
-	globalDepsChanged := lib.DidGlobalDependenciesChange(&lockBefore.GlobalDependencies, &lockAfter.GlobalDependencies)
-	globalDepsInstalled := lib.WereGlobalDependenciesInstalled(&lockAfter.GlobalDependencies)
-	if c.Install || c.OnlyInstall || c.Upgrade {
-		if globalDepsChanged || !globalDepsInstalled || c.Upgrade {
-			err = lib.InstallGlobalDependencies(&lockAfter.GlobalDependencies)
-			if err != nil {
-				lib.Logger.Error("something went wrong while trying to install global dependencies", "err", err)
-				return err
-			}
-		} else {
-			lib.Logger.Info("global dependencies didn't change since last installation, not installing", "depsChanged", globalDepsChanged, "previouslyInstalled", globalDepsInstalled)
+		change.AddedDeps, change.RemovedDeps = lib.GlobalDepsChange(depsBefore, lockAfter.GlobalDependencies)
+		err = lockAfter.PersistGlobalDepsSelection(c.SourceCfgDir)
+		if err != nil {
+			return err
 		}
 	}
 
-	if !c.OnlyUninstall && !c.OnlyInstall {
-		toSymlink := lockAfter.Configs
-
-		if c.CopyMode {
-			err = lib.Copy(c, toSymlink)
-		} else {
-			err = lib.Symlink(c, toSymlink)
-		}
+	if m.userChoices.CommitSelection {
+		err = lib.CommitSelection(c.SourceDir, c.SourceCfgDir, change)
 		if err != nil {
-			c.Logger.Error("encountered an error while copying/symlinking", "error", err)
+			lib.Logger.Error("something went wrong while trying to commit the selection", "err", err)
 			return err
 		}
-
-		toRemove := lockAfter.HiddenConfigs
-		err = lib.Remove(c, toRemove)
-	} else {
-		lib.Logger.Info("skipping copying/symlinking the config, because --only-install or --only-uninstall was passed")
-	}
-
-	if (c.Install || c.OnlyInstall || c.Upgrade) && !c.OnlyUninstall {
-		infoForUpdate := lib.Install(lockAfter)
-		lockAfter.UpdateInstallInfo(infoForUpdate)
 	}
 
-	if (c.Uninstall || c.OnlyUninstall) && !c.OnlyInstall {
-		infoForUpdate := lib.Uninstall(lockAfter)
-		lockAfter.UpdateInstallInfo(infoForUpdate)
-	}
+	lib.CopyInstallInfo(lockBefore, lockAfter)
 
-	err = lockAfter.Save(c.LockfilePath, c.DefaultIndent)
-	if err != nil {
-		lib.Logger.Error("something went wrong while trying to save the lockfile", "err", err)
+	err = deploy(ctx, c, lockBefore, lockAfter, report)
+	if err != nil && !interrupted(ctx, err) {
+		return err
 	}
-
-	diff := lib.DiffLocks(*lockBefore, *lockAfter)
-	err = diff.Save(c.LockfileDiffPath, c.DefaultIndent)
+	saveLockfiles(c, lockBefore, lockAfter, report)
+	printFailures(os.Stdout, report)
 	if err != nil {
-		lib.Logger.Error("something went wrong while trying to save the lockfile diff", "err", err)
+		return fmt.Errorf("interrupted, the lockfile was saved with what was completed: %w", err)
 	}
-
-	return nil
+	return report.Err()
 }